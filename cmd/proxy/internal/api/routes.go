@@ -0,0 +1,120 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/discovery/memory"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+)
+
+// maxRoutesBody limits PUT /routes request bodies.
+const maxRoutesBody = 1 << 20
+
+// routesAPI serves runtime route management for a memory resolver.
+type routesAPI struct {
+	resolver *memory.Resolver
+	token    string
+}
+
+// HandleRoutes registers the routes API, authenticated with a bearer token:
+//
+//	GET    /routes                  all routes
+//	GET    /routes/{deployment_id}  routes of one deployment
+//	PUT    /routes/{deployment_id}  replace them, body {"backends": [...]} in the backends file format
+//	DELETE /routes/{deployment_id}  remove them
+//
+// Changes apply to new connections; established sessions keep their backend.
+func (s *HealthServer) HandleRoutes(resolver *memory.Resolver, token string) {
+	api := &routesAPI{resolver: resolver, token: token}
+	s.mux.Handle("GET /routes", api.authenticated(api.list))
+	s.mux.Handle("GET /routes/{deployment_id}", api.authenticated(api.get))
+	s.mux.Handle("PUT /routes/{deployment_id}", api.authenticated(api.put))
+	s.mux.Handle("DELETE /routes/{deployment_id}", api.authenticated(api.delete))
+}
+
+func (a *routesAPI) authenticated(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="xdatabase-proxy"`)
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		next(w, r)
+	})
+}
+
+func (a *routesAPI) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.resolver.Snapshot())
+}
+
+func (a *routesAPI) get(w http.ResponseWriter, r *http.Request) {
+	deploymentID := r.PathValue("deployment_id")
+	backends, ok := a.resolver.Deployment(deploymentID)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("deployment not found: "+deploymentID))
+		return
+	}
+	writeJSON(w, http.StatusOK, memory.BackendsFile{Backends: backends})
+}
+
+func (a *routesAPI) put(w http.ResponseWriter, r *http.Request) {
+	deploymentID := r.PathValue("deployment_id")
+
+	var body memory.BackendsFile
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRoutesBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	created, err := a.resolver.SetDeployment(deploymentID, body.Backends)
+	if err != nil {
+		// Validation errors are the client's; persistence errors are ours
+		status := http.StatusBadRequest
+		if errors.Is(err, memory.ErrPersist) {
+			status = http.StatusInternalServerError
+		}
+		writeError(w, status, err)
+		return
+	}
+	logger.Info("Routes updated via API", "deployment_id", deploymentID, "created", created, "remote_addr", r.RemoteAddr)
+
+	backends, _ := a.resolver.Deployment(deploymentID)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, memory.BackendsFile{Backends: backends})
+}
+
+func (a *routesAPI) delete(w http.ResponseWriter, r *http.Request) {
+	deploymentID := r.PathValue("deployment_id")
+
+	deleted, err := a.resolver.DeleteDeployment(deploymentID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, errors.New("deployment not found: "+deploymentID))
+		return
+	}
+	logger.Info("Routes deleted via API", "deployment_id", deploymentID, "remote_addr", r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/discovery/memory"
+)
+
+const testToken = "s3cret"
+
+func TestRoutesAPI(t *testing.T) {
+	resolver, err := memory.NewResolver("")
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	server := NewHealthServer(":0")
+	server.HandleRoutes(resolver, testToken)
+
+	db1 := `{"backends":[{"addresses":["10.0.0.1:5432"]}]}`
+	db1Moved := `{"backends":[{"addresses":["10.0.0.2:5432",{"address":"10.0.0.3:5432","weight":2}]}]}`
+
+	steps := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+		wantBody   string // Substring of the response body
+	}{
+		{name: "missing token", method: "GET", path: "/routes", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", method: "GET", path: "/routes", token: "guess", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token on change", method: "PUT", path: "/routes/db1", token: "guess", body: db1, wantStatus: http.StatusUnauthorized},
+		{name: "get unknown", method: "GET", path: "/routes/db1", token: testToken, wantStatus: http.StatusNotFound},
+		{name: "create", method: "PUT", path: "/routes/db1", token: testToken, body: db1, wantStatus: http.StatusCreated, wantBody: `"deployment_id":"db1"`},
+		{name: "replace", method: "PUT", path: "/routes/db1", token: testToken, body: db1Moved, wantStatus: http.StatusOK, wantBody: `"10.0.0.2:5432"`},
+		{name: "get", method: "GET", path: "/routes/db1", token: testToken, wantStatus: http.StatusOK, wantBody: `{"address":"10.0.0.3:5432","weight":2}`},
+		{name: "list", method: "GET", path: "/routes", token: testToken, wantStatus: http.StatusOK, wantBody: `"deployment_id":"db1"`},
+		{name: "invalid address", method: "PUT", path: "/routes/db2", token: testToken, body: `{"backends":[{"addresses":["10.0.0.4"]}]}`, wantStatus: http.StatusBadRequest},
+		{name: "other deployment_id", method: "PUT", path: "/routes/db2", token: testToken, body: `{"backends":[{"deployment_id":"db1","addresses":["10.0.0.4:5432"]}]}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown field", method: "PUT", path: "/routes/db2", token: testToken, body: `{"backend":[]}`, wantStatus: http.StatusBadRequest},
+		{name: "delete", method: "DELETE", path: "/routes/db1", token: testToken, wantStatus: http.StatusNoContent},
+		{name: "delete again", method: "DELETE", path: "/routes/db1", token: testToken, wantStatus: http.StatusNotFound},
+		{name: "get deleted", method: "GET", path: "/routes/db1", token: testToken, wantStatus: http.StatusNotFound},
+	}
+
+	for _, step := range steps {
+		req := httptest.NewRequest(step.method, step.path, strings.NewReader(step.body))
+		if step.token != "" {
+			req.Header.Set("Authorization", "Bearer "+step.token)
+		}
+		rec := httptest.NewRecorder()
+		server.mux.ServeHTTP(rec, req)
+
+		if rec.Code != step.wantStatus {
+			t.Fatalf("%s: %s %s = %d %s, want %d", step.name, step.method, step.path, rec.Code, rec.Body, step.wantStatus)
+		}
+		if step.wantBody != "" && !strings.Contains(rec.Body.String(), step.wantBody) {
+			t.Errorf("%s: body = %s, want it to contain %s", step.name, rec.Body, step.wantBody)
+		}
+		if rec.Code >= 400 {
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body["error"] == "" {
+				t.Errorf("%s: error body = %s, want {\"error\": ...}", step.name, rec.Body)
+			}
+		}
+
+		if step.name == "replace" {
+			// Changes apply to the next resolution
+			backends, err := resolver.Resolve(context.Background(), core.RoutingMetadata{"deployment_id": "db1"}, core.DatabaseTypePostgresql)
+			if err != nil || len(backends) != 2 {
+				t.Errorf("resolve after replace = %v, %v, want 2 backends", backends, err)
+			}
+		}
+	}
+
+	if _, err := resolver.Resolve(context.Background(), core.RoutingMetadata{"deployment_id": "db1"}, core.DatabaseTypePostgresql); err == nil {
+		t.Errorf("deleted deployment still resolves")
+	}
+}
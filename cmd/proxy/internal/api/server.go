@@ -2,15 +2,35 @@ package api
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"sync"
 	"sync/atomic"
 
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/metrics"
 )
 
 type HealthServer struct {
 	server *http.Server
-	ready  atomic.Bool
+	mux    *http.ServeMux
+	ready  atomic.Bool // Set while the proxy listener accepts connections
+
+	mu     sync.RWMutex
+	checks []core.ReadinessCheck
+}
+
+// readinessReport is the /ready response body.
+type readinessReport struct {
+	Ready  bool               `json:"ready"`
+	Checks []readinessOutcome `json:"checks"`
+}
+
+type readinessOutcome struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
 }
 
 func NewHealthServer(addr string) *HealthServer {
@@ -20,6 +40,7 @@ func NewHealthServer(addr string) *HealthServer {
 			Addr:    addr,
 			Handler: mux,
 		},
+		mux: mux,
 	}
 
 	// Default to not ready until explicitly set
@@ -27,10 +48,16 @@ func NewHealthServer(addr string) *HealthServer {
 
 	mux.HandleFunc("/health", hs.handleHealth)
 	mux.HandleFunc("/ready", hs.handleReady)
+	mux.Handle("/metrics", metrics.Handler())
 
 	return hs
 }
 
+// Handle registers an additional endpoint, e.g. /backends.
+func (s *HealthServer) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
 func (s *HealthServer) Start() {
 	go func() {
 		logger.Info("Health server listening", "addr", s.server.Addr)
@@ -44,21 +71,51 @@ func (s *HealthServer) Stop(ctx context.Context) error {
 	return s.server.Shutdown(ctx)
 }
 
+// SetReady reports whether the proxy listener is bound and accepting
+// connections; it is the "listener" readiness check.
 func (s *HealthServer) SetReady(ready bool) {
 	s.ready.Store(ready)
 }
 
+// AddReadinessCheck adds a check that must pass for /ready to report ready.
+func (s *HealthServer) AddReadinessCheck(check core.ReadinessCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks = append(s.checks, check)
+}
+
 func (s *HealthServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
 }
 
+// handleReady runs every readiness check and reports each outcome. The proxy
+// is ready only if all of them pass.
 func (s *HealthServer) handleReady(w http.ResponseWriter, r *http.Request) {
-	if s.ready.Load() {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ready"))
-	} else {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte("not ready"))
+	s.mu.RLock()
+	checks := append([]core.ReadinessCheck{{Name: "listener", Check: s.listenerReady}}, s.checks...)
+	s.mu.RUnlock()
+
+	report := readinessReport{Ready: true, Checks: make([]readinessOutcome, 0, len(checks))}
+	for _, check := range checks {
+		outcome := readinessOutcome{Name: check.Name, Ready: true}
+		if err := check.Check(); err != nil {
+			outcome.Ready, outcome.Error = false, err.Error()
+			report.Ready = false
+		}
+		report.Checks = append(report.Checks, outcome)
+	}
+
+	status := http.StatusOK
+	if !report.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func (s *HealthServer) listenerReady() error {
+	if !s.ready.Load() {
+		return errors.New("not accepting connections (starting up or draining)")
 	}
+	return nil
 }
@@ -0,0 +1,146 @@
+package balancer
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Strategy names accepted by NewStrategy (LOAD_BALANCING_STRATEGY).
+const (
+	StrategyRoundRobin       = "round-robin"
+	StrategyLeastConnections = "least-connections"
+	StrategyRandom           = "random"
+)
+
+// Strategy picks one address out of the candidates of a backend.
+// key identifies the backend (e.g. namespace/service) so state is kept per backend.
+type Strategy interface {
+	Pick(key string, addresses []string) string
+}
+
+// NewStrategy returns the strategy with the given name.
+// tracker is used by least-connections and must be the one the proxy reports sessions to.
+func NewStrategy(name string, tracker *ConnectionTracker) (Strategy, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case StrategyRoundRobin, "":
+		return &RoundRobin{}, nil
+	case StrategyLeastConnections:
+		if tracker == nil {
+			return nil, fmt.Errorf("%s requires a connection tracker", StrategyLeastConnections)
+		}
+		return &LeastConnections{Tracker: tracker}, nil
+	case StrategyRandom:
+		return Random{}, nil
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy: %s", name)
+	}
+}
+
+// RoundRobin cycles through the addresses of each backend.
+type RoundRobin struct {
+	counters sync.Map // key -> *atomic.Uint64
+}
+
+func (s *RoundRobin) Pick(key string, addresses []string) string {
+	if len(addresses) == 0 {
+		return ""
+	}
+	counter, _ := s.counters.LoadOrStore(key, &atomic.Uint64{})
+	n := counter.(*atomic.Uint64).Add(1) - 1
+	return addresses[n%uint64(len(addresses))]
+}
+
+// Random picks an address uniformly at random.
+type Random struct{}
+
+func (Random) Pick(key string, addresses []string) string {
+	if len(addresses) == 0 {
+		return ""
+	}
+	return addresses[rand.IntN(len(addresses))]
+}
+
+// LeastConnections picks the address with the fewest active sessions,
+// choosing randomly among ties so idle backends are not hit in list order.
+type LeastConnections struct {
+	Tracker *ConnectionTracker
+}
+
+func (s *LeastConnections) Pick(key string, addresses []string) string {
+	if len(addresses) == 0 {
+		return ""
+	}
+	best, ties := "", 0
+	least := -1
+	for _, address := range addresses {
+		active := s.Tracker.Active(address)
+		switch {
+		case least < 0 || active < least:
+			best, least, ties = address, active, 1
+		case active == least:
+			// Reservoir sampling over the tied addresses
+			ties++
+			if rand.IntN(ties) == 0 {
+				best = address
+			}
+		}
+	}
+	return best
+}
+
+// ConnectionTracker counts active sessions per backend, identified by
+// core.Backend.Key. It implements core.ConnectionTracker.
+type ConnectionTracker struct {
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func NewConnectionTracker() *ConnectionTracker {
+	return &ConnectionTracker{active: make(map[string]int)}
+}
+
+func (t *ConnectionTracker) Acquire(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active[key]++
+}
+
+func (t *ConnectionTracker) Release(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active[key] <= 1 {
+		delete(t.active, key)
+		return
+	}
+	t.active[key]--
+}
+
+// Active returns the number of sessions currently open to the backend with key.
+func (t *ConnectionTracker) Active(key string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active[key]
+}
+
+// PickWeighted returns an index chosen with probability proportional to its
+// weight, or -1 if no weight is positive.
+func PickWeighted(weights []int) int {
+	total := 0
+	for _, weight := range weights {
+		total += max(weight, 0)
+	}
+	if total == 0 {
+		return -1
+	}
+
+	n := rand.IntN(total)
+	for i, weight := range weights {
+		if n -= max(weight, 0); n < 0 {
+			return i
+		}
+	}
+	return -1
+}
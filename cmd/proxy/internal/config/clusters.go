@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Precedence values of KUBE_CLUSTER_PRECEDENCE.
+var validClusterPrecedences = []string{"first", "merge", "reject"}
+
+// KubeCluster is one cluster of multi-cluster Kubernetes discovery.
+type KubeCluster struct {
+	Name           string
+	KubeConfigPath string // Empty = KUBECONFIG, then in-cluster config
+	Context        string // Empty = current context of the kubeconfig
+	Domain         string // DNS domain of service addresses in this cluster
+}
+
+// parseKubeClusters parses KUBE_CLUSTERS entries of the form
+// name[=kubeconfig][?context=name&domain=cluster.local].
+func parseKubeClusters(entries []string) ([]KubeCluster, error) {
+	clusters := make([]KubeCluster, 0, len(entries))
+	for _, entry := range entries {
+		spec, rawOptions, _ := strings.Cut(entry, "?")
+		name, kubeconfig, _ := strings.Cut(spec, "=")
+		cluster := KubeCluster{
+			Name:           strings.TrimSpace(name),
+			KubeConfigPath: strings.TrimSpace(kubeconfig),
+			Domain:         "cluster.local",
+		}
+		if cluster.Name == "" {
+			return nil, fmt.Errorf("cluster entry %q has no name", entry)
+		}
+		for _, other := range clusters {
+			if other.Name == cluster.Name {
+				return nil, fmt.Errorf("cluster %s is listed more than once", cluster.Name)
+			}
+		}
+
+		options, err := url.ParseQuery(rawOptions)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: invalid options: %w", cluster.Name, err)
+		}
+		for option := range options {
+			value := options.Get(option)
+			switch option {
+			case "context":
+				cluster.Context = value
+			case "domain":
+				if value == "" {
+					return nil, fmt.Errorf("cluster %s: empty domain", cluster.Name)
+				}
+				cluster.Domain = strings.Trim(value, ".")
+			default:
+				return nil, fmt.Errorf("cluster %s: unknown option: %s", cluster.Name, option)
+			}
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters, nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // RuntimeEnvironment represents the execution environment
@@ -22,6 +23,8 @@ type DiscoveryMode string
 const (
 	DiscoveryKubernetes DiscoveryMode = "kubernetes"
 	DiscoveryStatic     DiscoveryMode = "static"
+	DiscoveryDNS        DiscoveryMode = "dns"
+	DiscoveryChained    DiscoveryMode = "chain" // The modes in DiscoveryChain, tried in order
 )
 
 // TLSMode represents TLS certificate source
@@ -44,14 +47,75 @@ type Config struct {
 	Namespace string // Only for Kubernetes runtime
 
 	// Server
-	HealthServerPort string
-	ProxyStartPort   string
+	HealthServerPort     string
+	ProxyStartPort       string
+	ShutdownDrainTimeout time.Duration // Max time to wait for active sessions on SIGTERM/SIGINT
+
+	// PROXY protocol (client listener)
+	ProxyProtocolEnabled       bool
+	ProxyProtocolTrustedCIDRs  []string      // Peers allowed to send a header (required when enabled)
+	ProxyProtocolHeaderTimeout time.Duration // Max time to wait for the header from a trusted peer
+	BackendProxyProtocol       bool          // Send a PROXY v2 header on backend connections
+
+	// Routing
+	RoutingExtractors   []string // Ordered extractor names (empty = sni if configured, then username_suffix)
+	SNIHostnameTemplate string   // e.g. "{deployment}.{pool?}.db.example.com"
+
+	// Backend TLS
+	BackendSSLMode     string // disable, prefer, require, verify-ca, verify-full
+	BackendSSLRootCert string // CA bundle used to verify backend certificates
+
+	// Backend connections
+	BackendDialTimeout    time.Duration // Per-candidate connect timeout
+	BackendConnectRetries int           // Extra passes over the candidate list after all failed
+	BackendRetryBackoff   time.Duration // Wait before the first retry, doubled on each retry
+
+	// Backend health checking
+	HealthCheckEnabled          bool
+	HealthCheckType             string // tcp, postgresql
+	HealthCheckInterval         time.Duration
+	HealthCheckTimeout          time.Duration
+	HealthCheckFailureThreshold int // Consecutive failures before ejection
+	HealthCheckSuccessThreshold int // Consecutive successes before restoring
 
 	// Backend Discovery
-	DiscoveryMode  DiscoveryMode
-	StaticBackends string
-	KubeConfigPath string
-	KubeContext    string
+	DiscoveryMode                DiscoveryMode
+	DiscoveryChain               []string // Modes tried in order when DiscoveryMode is chain
+	StaticBackends               string
+	StaticBackendsFile           string        // YAML/JSON backends file, reloaded on change
+	StaticBackendsReloadInterval time.Duration // How often StaticBackendsFile is checked for changes
+	KubeConfigPath               string
+	KubeContext                  string
+	KubeClusters                 []KubeCluster // Watch several clusters instead of one (KUBE_CLUSTERS)
+	KubeClusterPrecedence        string        // first, merge, reject: deployments found in several clusters
+
+	// DNS SRV discovery
+	DNSSRVTemplate string        // e.g. "_postgres._tcp.{deployment}.{pool?}.db.internal"
+	DNSServer      string        // host:port of the DNS server (empty = system resolver)
+	DNSCacheTTL    time.Duration // How long SRV answers are reused
+	DNSTimeout     time.Duration // Timeout of a single SRV lookup
+
+	// Resolution caching
+	ResolverCacheEnabled     bool
+	ResolverCacheTTL         time.Duration // How long resolved backends are reused
+	ResolverCacheNegativeTTL time.Duration // How long "not found" is reused (0 = not cached)
+	ResolverCacheStaleTTL    time.Duration // Max age of backends served while discovery fails
+	ResolverCacheMaxEntries  int
+
+	// Runtime route management (static discovery)
+	RoutesAPIEnabled bool   // Serve GET/PUT/DELETE /routes on the health server
+	RoutesAPIPersist bool   // Write route changes back to STATIC_BACKENDS_FILE
+	APIToken         string // Bearer token required by the routes API
+
+	// Kubernetes discovery scope
+	DiscoveryNamespaces        []string      // Watch only these namespaces (empty = all)
+	DiscoveryNamespaceSelector string        // Watch namespaces matching this label selector
+	DiscoveryLabelPrefix       string        // Service label prefix, e.g. "xdatabase-proxy"
+	DiscoveryEndpointSlices    bool          // Route to ready pod IPs instead of the service DNS name
+	DiscoveryRoutesEnabled     bool          // Also route through XDatabaseRoute resources
+	DiscoverySyncTimeout       time.Duration // Max wait for the initial informer sync at startup
+	DiscoveryStaleTimeout      time.Duration // Not ready after an informer could not list or watch this long (0 = never)
+	LoadBalancingStrategy      string        // round-robin, least-connections, random
 
 	// TLS Configuration
 	TLSEnabled              bool
@@ -76,14 +140,74 @@ func LoadFromEnv() (*Config, error) {
 		Namespace: determineNamespace(),
 
 		// Server
-		HealthServerPort: getEnv("HEALTH_SERVER_PORT", "8080"),
-		ProxyStartPort:   getEnv("PROXY_START_PORT", "5432"),
+		HealthServerPort:     getEnv("HEALTH_SERVER_PORT", "8080"),
+		ProxyStartPort:       getEnv("PROXY_START_PORT", "5432"),
+		ShutdownDrainTimeout: getEnvDuration("SHUTDOWN_DRAIN_TIMEOUT", 30*time.Second),
+
+		// PROXY protocol
+		ProxyProtocolEnabled:       getEnvBool("PROXY_PROTOCOL_ENABLED", false),
+		ProxyProtocolTrustedCIDRs:  getEnvList("PROXY_PROTOCOL_TRUSTED_CIDRS"),
+		ProxyProtocolHeaderTimeout: getEnvDuration("PROXY_PROTOCOL_HEADER_TIMEOUT", 5*time.Second),
+		BackendProxyProtocol:       getEnvBool("BACKEND_PROXY_PROTOCOL", false),
+
+		// Routing
+		RoutingExtractors:   getEnvList("ROUTING_EXTRACTORS"),
+		SNIHostnameTemplate: getEnv("SNI_HOSTNAME_TEMPLATE", ""),
+
+		// Backend TLS
+		BackendSSLMode:     getEnv("BACKEND_SSLMODE", "disable"),
+		BackendSSLRootCert: getEnv("BACKEND_SSL_ROOT_CERT", ""),
+
+		// Backend connections
+		BackendDialTimeout:    getEnvDuration("BACKEND_DIAL_TIMEOUT", 5*time.Second),
+		BackendConnectRetries: getEnvInt("BACKEND_CONNECT_RETRIES", 2),
+		BackendRetryBackoff:   getEnvDuration("BACKEND_RETRY_BACKOFF", 100*time.Millisecond),
+
+		// Backend health checking
+		HealthCheckEnabled:          getEnvBool("HEALTH_CHECK_ENABLED", false),
+		HealthCheckType:             getEnv("HEALTH_CHECK_TYPE", "postgresql"),
+		HealthCheckInterval:         getEnvDuration("HEALTH_CHECK_INTERVAL", 10*time.Second),
+		HealthCheckTimeout:          getEnvDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+		HealthCheckFailureThreshold: getEnvInt("HEALTH_CHECK_FAILURE_THRESHOLD", 3),
+		HealthCheckSuccessThreshold: getEnvInt("HEALTH_CHECK_SUCCESS_THRESHOLD", 2),
 
 		// Backend Discovery
-		DiscoveryMode:  determineDiscoveryMode(),
-		StaticBackends: getEnv("STATIC_BACKENDS", ""),
-		KubeConfigPath: getEnv("KUBECONFIG", ""),
-		KubeContext:    getEnv("KUBE_CONTEXT", ""),
+		DiscoveryMode:                determineDiscoveryMode(),
+		DiscoveryChain:               getEnvList("DISCOVERY_CHAIN"),
+		StaticBackends:               getEnv("STATIC_BACKENDS", ""),
+		StaticBackendsFile:           getEnv("STATIC_BACKENDS_FILE", ""),
+		StaticBackendsReloadInterval: getEnvDuration("STATIC_BACKENDS_RELOAD_INTERVAL", 5*time.Second),
+		KubeConfigPath:               getEnv("KUBECONFIG", ""),
+		KubeContext:                  getEnv("KUBE_CONTEXT", ""),
+		KubeClusterPrecedence:        getEnv("KUBE_CLUSTER_PRECEDENCE", "first"),
+
+		// DNS SRV discovery
+		DNSSRVTemplate: getEnv("DNS_SRV_TEMPLATE", ""),
+		DNSServer:      getEnv("DNS_SERVER", ""),
+		DNSCacheTTL:    getEnvDuration("DNS_CACHE_TTL", 30*time.Second),
+		DNSTimeout:     getEnvDuration("DNS_TIMEOUT", 2*time.Second),
+
+		// Resolution caching
+		ResolverCacheEnabled:     getEnvBool("RESOLVER_CACHE_ENABLED", false),
+		ResolverCacheTTL:         getEnvDuration("RESOLVER_CACHE_TTL", 5*time.Second),
+		ResolverCacheNegativeTTL: getEnvDuration("RESOLVER_CACHE_NEGATIVE_TTL", 5*time.Second),
+		ResolverCacheStaleTTL:    getEnvDuration("RESOLVER_CACHE_STALE_TTL", 5*time.Minute),
+		ResolverCacheMaxEntries:  getEnvInt("RESOLVER_CACHE_MAX_ENTRIES", 10000),
+
+		// Runtime route management
+		RoutesAPIEnabled: getEnvBool("ROUTES_API_ENABLED", false),
+		RoutesAPIPersist: getEnvBool("ROUTES_API_PERSIST", false),
+		APIToken:         getEnv("API_TOKEN", ""),
+
+		// Kubernetes discovery scope
+		DiscoveryNamespaces:        getEnvList("DISCOVERY_NAMESPACES"),
+		DiscoveryNamespaceSelector: getEnv("DISCOVERY_NAMESPACE_SELECTOR", ""),
+		DiscoveryLabelPrefix:       getEnv("DISCOVERY_LABEL_PREFIX", "xdatabase-proxy"),
+		DiscoveryEndpointSlices:    getEnvBool("DISCOVERY_ENDPOINT_SLICES", false),
+		DiscoveryRoutesEnabled:     getEnvBool("DISCOVERY_ROUTES_ENABLED", false),
+		DiscoverySyncTimeout:       getEnvDuration("DISCOVERY_SYNC_TIMEOUT", 2*time.Minute),
+		DiscoveryStaleTimeout:      getEnvDuration("DISCOVERY_STALE_TIMEOUT", time.Minute),
+		LoadBalancingStrategy:      getEnv("LOAD_BALANCING_STRATEGY", "round-robin"),
 
 		// TLS
 		TLSEnabled:              getEnvBool("TLS_ENABLED", true),
@@ -96,6 +220,12 @@ func LoadFromEnv() (*Config, error) {
 		TLSRenewalThresholdDays: getEnvInt("TLS_RENEWAL_THRESHOLD_DAYS", 30),
 	}
 
+	clusters, err := parseKubeClusters(getEnvList("KUBE_CLUSTERS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid KUBE_CLUSTERS: %w", err)
+	}
+	cfg.KubeClusters = clusters
+
 	// Legacy support
 	cfg.applyLegacySupport()
 
@@ -128,15 +258,134 @@ func (c *Config) validate() error {
 			if c.TLSSecretName == "" {
 				return fmt.Errorf("TLS_SECRET_NAME must be set when using kubernetes TLS mode")
 			}
-			if c.DiscoveryMode == DiscoveryStatic {
-				return fmt.Errorf("kubernetes TLS mode requires kubernetes discovery (cannot use STATIC_BACKENDS)")
+			if !c.UsesDiscovery(DiscoveryKubernetes) {
+				return fmt.Errorf("kubernetes TLS mode requires kubernetes discovery (DISCOVERY_MODE=%s)", c.DiscoveryMode)
 			}
 		}
 	}
 
+	// Validate backend TLS
+	validSSLModes := []string{"disable", "prefer", "require", "verify-ca", "verify-full"}
+	if !contains(validSSLModes, c.BackendSSLMode) {
+		return fmt.Errorf("unsupported BACKEND_SSLMODE: %s (supported: %s)",
+			c.BackendSSLMode, strings.Join(validSSLModes, ", "))
+	}
+
+	// Without a trusted list any client could claim any address
+	if c.ProxyProtocolEnabled && len(c.ProxyProtocolTrustedCIDRs) == 0 {
+		return fmt.Errorf("PROXY_PROTOCOL_TRUSTED_CIDRS must be set when PROXY_PROTOCOL_ENABLED is true (use 0.0.0.0/0,::/0 to trust every peer)")
+	}
+
+	if c.BackendConnectRetries < 0 {
+		return fmt.Errorf("BACKEND_CONNECT_RETRIES must not be negative")
+	}
+
+	// Validate health checking
+	if c.HealthCheckEnabled {
+		validProbes := []string{"tcp", "postgresql"}
+		if !contains(validProbes, c.HealthCheckType) {
+			return fmt.Errorf("unsupported HEALTH_CHECK_TYPE: %s (supported: %s)",
+				c.HealthCheckType, strings.Join(validProbes, ", "))
+		}
+		if c.HealthCheckFailureThreshold < 1 || c.HealthCheckSuccessThreshold < 1 {
+			return fmt.Errorf("HEALTH_CHECK_FAILURE_THRESHOLD and HEALTH_CHECK_SUCCESS_THRESHOLD must be at least 1")
+		}
+	}
+
 	// Validate discovery mode
-	if c.DiscoveryMode == DiscoveryKubernetes && c.Runtime == RuntimeContainer && c.KubeConfigPath == "" {
-		return fmt.Errorf("kubernetes discovery in container runtime requires KUBECONFIG path")
+	if c.DiscoveryMode == DiscoveryChained {
+		validModes := []string{string(DiscoveryStatic), string(DiscoveryKubernetes), string(DiscoveryDNS)}
+		if len(c.DiscoveryChain) == 0 {
+			return fmt.Errorf("DISCOVERY_CHAIN must list at least one discovery mode")
+		}
+		for i, mode := range c.DiscoveryChain {
+			if !contains(validModes, mode) {
+				return fmt.Errorf("unsupported DISCOVERY_CHAIN mode: %s (supported: %s)", mode, strings.Join(validModes, ", "))
+			}
+			if contains(c.DiscoveryChain[:i], mode) {
+				return fmt.Errorf("DISCOVERY_CHAIN lists %s more than once", mode)
+			}
+		}
+	}
+	if c.UsesDiscovery(DiscoveryKubernetes) && c.Runtime == RuntimeContainer && c.KubeConfigPath == "" {
+		if len(c.KubeClusters) == 0 {
+			return fmt.Errorf("kubernetes discovery in container runtime requires KUBECONFIG path")
+		}
+		for _, cluster := range c.KubeClusters {
+			if cluster.KubeConfigPath == "" {
+				return fmt.Errorf("kubernetes discovery in container runtime requires KUBECONFIG path (cluster %s has none)", cluster.Name)
+			}
+		}
+	}
+	if len(c.KubeClusters) > 0 {
+		if !c.UsesDiscovery(DiscoveryKubernetes) {
+			return fmt.Errorf("KUBE_CLUSTERS requires kubernetes discovery")
+		}
+		if c.KubeContext != "" {
+			return fmt.Errorf("KUBE_CONTEXT and KUBE_CLUSTERS are mutually exclusive (set context= per cluster)")
+		}
+		if !contains(validClusterPrecedences, c.KubeClusterPrecedence) {
+			return fmt.Errorf("unsupported KUBE_CLUSTER_PRECEDENCE: %s (supported: %s)",
+				c.KubeClusterPrecedence, strings.Join(validClusterPrecedences, ", "))
+		}
+		// Service addresses are dialled by name, so each cluster needs its own domain;
+		// pod IPs are told apart by the cluster name on each backend
+		if !c.DiscoveryEndpointSlices {
+			for i, cluster := range c.KubeClusters {
+				for _, other := range c.KubeClusters[:i] {
+					if other.Domain == cluster.Domain {
+						return fmt.Errorf("clusters %s and %s share the domain %s; set domain= per cluster or DISCOVERY_ENDPOINT_SLICES=true", other.Name, cluster.Name, cluster.Domain)
+					}
+				}
+			}
+		}
+	}
+	if c.UsesDiscovery(DiscoveryDNS) && c.DNSSRVTemplate == "" {
+		return fmt.Errorf("DNS_SRV_TEMPLATE must be set for dns discovery")
+	}
+	if c.StaticBackends != "" && c.StaticBackendsFile != "" {
+		return fmt.Errorf("STATIC_BACKENDS and STATIC_BACKENDS_FILE are mutually exclusive")
+	}
+	if c.StaticBackendsReloadInterval <= 0 {
+		return fmt.Errorf("STATIC_BACKENDS_RELOAD_INTERVAL must be positive")
+	}
+	if c.ResolverCacheEnabled {
+		if c.ResolverCacheTTL <= 0 || c.ResolverCacheNegativeTTL < 0 || c.ResolverCacheMaxEntries < 1 {
+			return fmt.Errorf("RESOLVER_CACHE_TTL and RESOLVER_CACHE_MAX_ENTRIES must be positive and RESOLVER_CACHE_NEGATIVE_TTL must not be negative")
+		}
+		if c.ResolverCacheStaleTTL < c.ResolverCacheTTL {
+			return fmt.Errorf("RESOLVER_CACHE_STALE_TTL must not be shorter than RESOLVER_CACHE_TTL")
+		}
+	}
+	if c.RoutesAPIEnabled {
+		if !c.UsesDiscovery(DiscoveryStatic) {
+			return fmt.Errorf("ROUTES_API_ENABLED requires static discovery")
+		}
+		if c.APIToken == "" {
+			return fmt.Errorf("API_TOKEN must be set when ROUTES_API_ENABLED is true")
+		}
+		if c.RoutesAPIPersist && c.StaticBackendsFile == "" {
+			return fmt.Errorf("ROUTES_API_PERSIST requires STATIC_BACKENDS_FILE")
+		}
+	}
+	if len(c.DiscoveryNamespaces) > 0 && c.DiscoveryNamespaceSelector != "" {
+		return fmt.Errorf("DISCOVERY_NAMESPACES and DISCOVERY_NAMESPACE_SELECTOR are mutually exclusive")
+	}
+	if c.DiscoverySyncTimeout <= 0 {
+		return fmt.Errorf("DISCOVERY_SYNC_TIMEOUT must be positive")
+	}
+	if c.DiscoveryStaleTimeout < 0 {
+		return fmt.Errorf("DISCOVERY_STALE_TIMEOUT must not be negative")
+	}
+	if c.DiscoveryRoutesEnabled && !c.UsesDiscovery(DiscoveryKubernetes) {
+		return fmt.Errorf("DISCOVERY_ROUTES_ENABLED requires kubernetes discovery")
+	}
+
+	// Validate load balancing
+	validStrategies := []string{"round-robin", "least-connections", "random"}
+	if !contains(validStrategies, c.LoadBalancingStrategy) {
+		return fmt.Errorf("unsupported LOAD_BALANCING_STRATEGY: %s (supported: %s)",
+			c.LoadBalancingStrategy, strings.Join(validStrategies, ", "))
 	}
 
 	return nil
@@ -198,6 +447,32 @@ func getEnvInt(key string, defaultValue int) int {
 	return intValue
 }
 
+// getEnvList splits a comma-separated variable, dropping empty entries.
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvDuration parses a Go duration ("30s", "2m") or a plain number of seconds.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	return defaultValue
+}
+
 func determineRuntime() RuntimeEnvironment {
 	// Explicit runtime setting
 	if runtime := os.Getenv("RUNTIME"); runtime != "" {
@@ -244,17 +519,35 @@ func determineNamespace() string {
 	return "default"
 }
 
+// UsesDiscovery reports whether mode is the discovery mode or part of the chain.
+func (c *Config) UsesDiscovery(mode DiscoveryMode) bool {
+	if c.DiscoveryMode == DiscoveryChained {
+		return contains(c.DiscoveryChain, string(mode))
+	}
+	return c.DiscoveryMode == mode
+}
+
 func determineDiscoveryMode() DiscoveryMode {
+	// A chain overrides the single mode
+	if os.Getenv("DISCOVERY_CHAIN") != "" {
+		return DiscoveryChained
+	}
+
 	// Explicit mode
 	if mode := os.Getenv("DISCOVERY_MODE"); mode != "" {
-		if strings.ToLower(mode) == "static" {
+		switch strings.ToLower(mode) {
+		case "static":
 			return DiscoveryStatic
+		case "dns":
+			return DiscoveryDNS
+		case "chain":
+			return DiscoveryChained
 		}
 		return DiscoveryKubernetes
 	}
 
-	// Auto-detect: Static if STATIC_BACKENDS is set
-	if os.Getenv("STATIC_BACKENDS") != "" {
+	// Auto-detect: Static if STATIC_BACKENDS or STATIC_BACKENDS_FILE is set
+	if os.Getenv("STATIC_BACKENDS") != "" || os.Getenv("STATIC_BACKENDS_FILE") != "" {
 		return DiscoveryStatic
 	}
 
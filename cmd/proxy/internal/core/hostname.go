@@ -0,0 +1,120 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	hostnameLabelDeployment = "{deployment}"
+	hostnameLabelPool       = "{pool?}"
+)
+
+// HostnameTemplate maps hostnames to routing keys and back.
+// Templates are dot-separated labels where "{deployment}" stands for the
+// deployment ID and the optional "{pool?}" label is "pool" for pooled connections
+// and omitted otherwise, e.g. "{deployment}.{pool?}.db.example.com" matches both
+// "db-prod.pool.db.example.com" and "db-prod.db.example.com".
+type HostnameTemplate struct {
+	template string
+	labels   []string
+	pattern  *regexp.Regexp
+}
+
+// ParseHostnameTemplate compiles a hostname template.
+func ParseHostnameTemplate(template string) (*HostnameTemplate, error) {
+	template = strings.TrimSuffix(strings.TrimSpace(template), ".")
+	labels := strings.Split(template, ".")
+
+	var deploymentLabels, poolLabels int
+	var b strings.Builder
+	b.WriteString(`(?i)^`)
+	for i, label := range labels {
+		last := i == len(labels)-1
+		switch label {
+		case hostnameLabelPool:
+			poolLabels++
+			// The optional label carries its own separator so it can vanish entirely
+			if last {
+				b.WriteString(`(?:\.(?P<pool>pool))?`)
+			} else {
+				b.WriteString(`(?:(?P<pool>pool)\.)?`)
+			}
+			continue
+		case hostnameLabelDeployment:
+			deploymentLabels++
+			b.WriteString(`(?P<deployment>[^.]+)`)
+		case "":
+			return nil, fmt.Errorf("invalid hostname template %q: empty label", template)
+		default:
+			if strings.ContainsAny(label, "{}") {
+				return nil, fmt.Errorf("invalid hostname template %q: unknown placeholder %s", template, label)
+			}
+			b.WriteString(regexp.QuoteMeta(label))
+		}
+
+		nextIsTrailingPool := i == len(labels)-2 && labels[i+1] == hostnameLabelPool
+		if !last && !nextIsTrailingPool {
+			b.WriteString(`\.`)
+		}
+	}
+	b.WriteString(`$`)
+
+	if deploymentLabels != 1 {
+		return nil, fmt.Errorf("invalid hostname template %q: exactly one %s label required", template, hostnameLabelDeployment)
+	}
+	if poolLabels > 1 {
+		return nil, fmt.Errorf("invalid hostname template %q: at most one %s label allowed", template, hostnameLabelPool)
+	}
+
+	pattern, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid hostname template %q: %w", template, err)
+	}
+
+	return &HostnameTemplate{
+		template: template,
+		labels:   labels,
+		pattern:  pattern,
+	}, nil
+}
+
+// Match extracts the deployment ID and pool flag from a hostname.
+func (t *HostnameTemplate) Match(hostname string) (deploymentID string, pooled bool, ok bool) {
+	match := t.pattern.FindStringSubmatch(strings.TrimSuffix(hostname, "."))
+	if match == nil {
+		return "", false, false
+	}
+	for i, name := range t.pattern.SubexpNames() {
+		switch name {
+		case "deployment":
+			deploymentID = match[i]
+		case "pool":
+			pooled = match[i] != ""
+		}
+	}
+	return deploymentID, pooled, true
+}
+
+// Expand builds the hostname for a deployment ID and pool flag.
+func (t *HostnameTemplate) Expand(deploymentID string, pooled bool) string {
+	labels := make([]string, 0, len(t.labels))
+	for _, label := range t.labels {
+		switch label {
+		case hostnameLabelDeployment:
+			labels = append(labels, deploymentID)
+		case hostnameLabelPool:
+			if pooled {
+				labels = append(labels, "pool")
+			}
+		default:
+			labels = append(labels, label)
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+func (t *HostnameTemplate) String() string {
+	return t.template
+}
@@ -1,28 +1,157 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"net"
+	"sync"
+	"time"
 )
 
+// ErrServerClosed is returned by Serve after Shutdown has been called.
+var ErrServerClosed = errors.New("core: server closed")
+
+// ReadinessSetter is notified when the server stops accepting new connections,
+// so load balancers stop sending traffic before the listener goes away.
+type ReadinessSetter interface {
+	SetReady(ready bool)
+}
+
+// ReadinessCheck is a named condition reported on the readiness endpoint.
+// Check returns nil while the condition holds and an explanation otherwise.
+type ReadinessCheck struct {
+	Name  string
+	Check func() error
+}
+
 // Server is the generic TCP proxy server.
 // It depends ONLY on interfaces, not concrete implementations.
 type Server struct {
 	Listener          net.Listener
 	ConnectionHandler ConnectionHandler
+
+	// Readiness (optional) is flipped to not-ready when Shutdown starts.
+	Readiness ReadinessSetter
+
+	// DrainTimeout bounds how long Shutdown waits for in-flight connections
+	// before force-closing them. Zero means wait until the Shutdown context is done.
+	DrainTimeout time.Duration
+
+	mu           sync.Mutex
+	conns        map[net.Conn]struct{}
+	wg           sync.WaitGroup
+	shuttingDown bool
 }
 
 // Serve starts accepting connections.
+// It blocks until the listener fails or Shutdown is called, in which case ErrServerClosed is returned.
 func (s *Server) Serve() error {
 	for {
 		conn, err := s.Listener.Accept()
 		if err != nil {
+			if s.isShuttingDown() {
+				return ErrServerClosed
+			}
 			return err
 		}
+
+		if !s.trackConn(conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
 		go s.handleConnection(conn)
 	}
 }
 
+// Shutdown gracefully stops the server: it marks the server as not ready,
+// stops accepting new connections and waits for active connections to finish.
+// Connections still open when DrainTimeout elapses or ctx is done are force-closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.shuttingDown {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.shuttingDown = true
+	s.mu.Unlock()
+
+	if s.Readiness != nil {
+		s.Readiness.SetReady(false)
+	}
+
+	listenerErr := s.Listener.Close()
+
+	if s.DrainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.DrainTimeout)
+		defer cancel()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return listenerErr
+	case <-ctx.Done():
+		// Force-close remaining sessions; handlers observe the closed
+		// connection and unwind their backend connections on their own.
+		s.closeConns()
+		<-drained
+		return ctx.Err()
+	}
+}
+
+// ActiveConnections returns the number of connections currently being handled.
+func (s *Server) ActiveConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
 func (s *Server) handleConnection(clientConn net.Conn) {
+	defer s.untrackConn(clientConn)
+
 	// Delegate the entire lifecycle to the handler
 	s.ConnectionHandler.HandleConnection(clientConn)
 }
+
+// trackConn registers a live connection. It returns false if the server is
+// already shutting down, in which case the connection must not be handled.
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
+}
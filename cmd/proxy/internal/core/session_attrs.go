@@ -0,0 +1,70 @@
+package core
+
+import "fmt"
+
+// MetadataTargetSessionAttrs is the startup parameter (and metadata key) clients use
+// to ask for a primary or a replica, with libpq's target_session_attrs values.
+const MetadataTargetSessionAttrs = "target_session_attrs"
+
+// BackendRole is the replication role of a backend.
+type BackendRole string
+
+const (
+	BackendRolePrimary BackendRole = "primary"
+	BackendRoleReplica BackendRole = "replica"
+)
+
+// ParseBackendRole validates a role. An empty role means primary, so existing
+// single-service deployments keep working without a role.
+func ParseBackendRole(value string) (BackendRole, error) {
+	switch role := BackendRole(value); role {
+	case "":
+		return BackendRolePrimary, nil
+	case BackendRolePrimary, BackendRoleReplica:
+		return role, nil
+	default:
+		return "", fmt.Errorf("invalid role %q (supported: primary, replica)", value)
+	}
+}
+
+// TargetSessionAttrs mirrors libpq's target_session_attrs.
+type TargetSessionAttrs string
+
+const (
+	TargetSessionAttrsAny           TargetSessionAttrs = "any"
+	TargetSessionAttrsReadWrite     TargetSessionAttrs = "read-write"
+	TargetSessionAttrsReadOnly      TargetSessionAttrs = "read-only"
+	TargetSessionAttrsPrimary       TargetSessionAttrs = "primary"
+	TargetSessionAttrsStandby       TargetSessionAttrs = "standby"
+	TargetSessionAttrsPreferStandby TargetSessionAttrs = "prefer-standby"
+)
+
+// ParseTargetSessionAttrs validates a target_session_attrs value. Empty means any.
+func ParseTargetSessionAttrs(value string) (TargetSessionAttrs, error) {
+	switch attrs := TargetSessionAttrs(value); attrs {
+	case "":
+		return TargetSessionAttrsAny, nil
+	case TargetSessionAttrsAny, TargetSessionAttrsReadWrite, TargetSessionAttrsReadOnly,
+		TargetSessionAttrsPrimary, TargetSessionAttrsStandby, TargetSessionAttrsPreferStandby:
+		return attrs, nil
+	default:
+		return "", fmt.Errorf("invalid target_session_attrs %q (supported: any, read-write, read-only, primary, standby, prefer-standby)", value)
+	}
+}
+
+// Roles returns the acceptable backend roles in order of preference.
+// Primaries are treated as read-write and replicas as read-only (hot standbys).
+// Like libpq, prefer-standby falls back to a primary while read-only and
+// standby fail if no replica is available.
+func (a TargetSessionAttrs) Roles() []BackendRole {
+	switch a {
+	case TargetSessionAttrsReadWrite, TargetSessionAttrsPrimary:
+		return []BackendRole{BackendRolePrimary}
+	case TargetSessionAttrsReadOnly, TargetSessionAttrsStandby:
+		return []BackendRole{BackendRoleReplica}
+	case TargetSessionAttrsPreferStandby:
+		return []BackendRole{BackendRoleReplica, BackendRolePrimary}
+	default:
+		return []BackendRole{BackendRolePrimary, BackendRoleReplica}
+	}
+}
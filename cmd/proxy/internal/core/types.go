@@ -3,17 +3,185 @@ package core
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
+	"net/netip"
+	"slices"
 )
 
 // RoutingMetadata contains information extracted from the protocol handshake
 // used to determine the destination backend (e.g., "database": "finance").
 type RoutingMetadata map[string]string
 
-// BackendResolver defines how to find a backend address based on metadata.
+// Backend is a resolved backend endpoint together with per-backend connection options.
+// Zero-valued options mean "use the proxy-wide default".
+type Backend struct {
+	// Address is the host:port to dial.
+	Address string
+
+	// Cluster is the Kubernetes cluster the backend was discovered in, with
+	// multi-cluster discovery (empty otherwise). Pod IPs of different clusters
+	// may overlap, so it is part of Key.
+	Cluster string
+
+	// SendProxyProtocol overrides whether a PROXY v2 header is sent before the startup message.
+	SendProxyProtocol *bool
+
+	// SSLMode overrides the proxy-to-backend TLS mode.
+	SSLMode BackendSSLMode
+
+	// ServerName is the host name verified with sslmode=verify-full (empty = the
+	// host of Address), e.g. the service name of a pod reached by its IP.
+	ServerName string
+
+	// Role is the replication role, matched against target_session_attrs (empty = primary).
+	Role BackendRole
+
+	// AllowedSources restricts which client addresses may use this backend (empty = any).
+	AllowedSources []netip.Prefix
+}
+
+// Key identifies the backend for connection counting and health checks: its
+// address, qualified by the cluster when there is one.
+func (b Backend) Key() string {
+	if b.Cluster == "" {
+		return b.Address
+	}
+	return b.Cluster + "/" + b.Address
+}
+
+// Allows reports whether a client at addr may connect to the backend.
+func (b Backend) Allows(addr netip.Addr) bool {
+	if len(b.AllowedSources) == 0 {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range b.AllowedSources {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// BackendSSLMode mirrors libpq's sslmode for proxy-to-backend connections.
+type BackendSSLMode string
+
+const (
+	BackendSSLModeDisable    BackendSSLMode = "disable"
+	BackendSSLModePrefer     BackendSSLMode = "prefer"
+	BackendSSLModeRequire    BackendSSLMode = "require"
+	BackendSSLModeVerifyCA   BackendSSLMode = "verify-ca"
+	BackendSSLModeVerifyFull BackendSSLMode = "verify-full"
+)
+
+// ParseBackendSSLMode validates a libpq-style sslmode string.
+func ParseBackendSSLMode(value string) (BackendSSLMode, error) {
+	switch mode := BackendSSLMode(value); mode {
+	case BackendSSLModeDisable, BackendSSLModePrefer, BackendSSLModeRequire,
+		BackendSSLModeVerifyCA, BackendSSLModeVerifyFull:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid sslmode %q (supported: disable, prefer, require, verify-ca, verify-full)", value)
+	}
+}
+
+// RoutingRequest is the client handshake data available to a RoutingExtractor.
+type RoutingRequest struct {
+	// Params are the client's StartupMessage parameters. Extractors that match may
+	// rewrite them (e.g. strip a routing suffix from "user"); the result is what
+	// gets forwarded to the backend.
+	Params map[string]string
+
+	// ServerName is the TLS SNI host name (empty for plain-text connections).
+	ServerName string
+}
+
+// RoutingTarget identifies the deployment a client wants to reach.
+type RoutingTarget struct {
+	DeploymentID string
+	Pooled       bool
+}
+
+// RoutingExtractor derives the routing target from the client handshake.
+// Extractors are tried in a configured order; the first match wins.
+type RoutingExtractor interface {
+	Name() string
+	// Extract returns ok=false, leaving the request untouched, if the
+	// handshake does not follow the extractor's convention.
+	Extract(req *RoutingRequest) (target RoutingTarget, ok bool)
+}
+
+// BackendResolver defines how to find a backend based on metadata.
 // It is purely a lookup mechanism and knows nothing about the network.
+// It returns the candidate backends in the order they should be tried;
+// the proxy fails over to the next candidate if one cannot be reached.
+// Errors wrap ErrBackendNotFound when the resolver has no route for the
+// deployment, as opposed to failing to look it up.
 type BackendResolver interface {
-	Resolve(ctx context.Context, metadata RoutingMetadata, databaseType DatabaseType) (string, error)
+	Resolve(ctx context.Context, metadata RoutingMetadata, databaseType DatabaseType) ([]Backend, error)
+}
+
+// CandidateSet holds the candidate backends found by a lookup before they are
+// ordered. Order returns them in the order to try them; load balancing and
+// weights may order them differently on every call.
+type CandidateSet interface {
+	Order() []Backend
+}
+
+// CandidateResolver is a BackendResolver that can look candidates up without
+// ordering them, so a cached lookup is still balanced on every resolution.
+// Resolve is Lookup followed by Order.
+type CandidateResolver interface {
+	BackendResolver
+	Lookup(ctx context.Context, metadata RoutingMetadata, databaseType DatabaseType) (CandidateSet, error)
+}
+
+// OrderedCandidates is a CandidateSet that always keeps its order.
+type OrderedCandidates []Backend
+
+func (c OrderedCandidates) Order() []Backend {
+	return slices.Clone(c)
+}
+
+// LookupCandidates looks the candidates up through resolver, or resolves them
+// in a fixed order if resolver is not a CandidateResolver.
+func LookupCandidates(ctx context.Context, resolver BackendResolver, metadata RoutingMetadata, databaseType DatabaseType) (CandidateSet, error) {
+	if candidateResolver, ok := resolver.(CandidateResolver); ok {
+		return candidateResolver.Lookup(ctx, metadata, databaseType)
+	}
+	backends, err := resolver.Resolve(ctx, metadata, databaseType)
+	if err != nil {
+		return nil, err
+	}
+	return OrderedCandidates(backends), nil
+}
+
+// ErrBackendNotFound is wrapped by resolver errors for deployments they have no route for.
+var ErrBackendNotFound = errors.New("backend not found")
+
+// CancelKey identifies a backend session as announced in BackendKeyData:
+// the backend process ID and its secret key (raw bytes, variable length since protocol 3.2).
+type CancelKey struct {
+	ProcessID uint32
+	SecretKey string
+}
+
+// CancelKeyStore maps cancel keys to the backend that issued them so query
+// cancellation can be routed to the right server. Implementations may be
+// process-local or shared across proxy instances.
+type CancelKeyStore interface {
+	Register(ctx context.Context, key CancelKey, backend Backend) error
+	Lookup(ctx context.Context, key CancelKey) (Backend, bool, error)
+	Unregister(ctx context.Context, key CancelKey) error
+}
+
+// ConnectionTracker is told when sessions to a backend open and close, e.g.
+// for least-connections load balancing. Backends are identified by Backend.Key.
+type ConnectionTracker interface {
+	Acquire(key string)
+	Release(key string)
 }
 
 // ConnectionHandler defines the interface for handling a client connection.
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/metrics"
+)
+
+// Cache results for ResolverCacheLookups.
+const (
+	ResultHit         = "hit"
+	ResultNegativeHit = "negative_hit"
+	ResultMiss        = "miss"
+	ResultStale       = "stale"
+)
+
+// Options configure a Resolver. Zero values fall back to the defaults in NewResolver.
+type Options struct {
+	TTL         time.Duration // How long resolved backends are reused
+	NegativeTTL time.Duration // How long core.ErrBackendNotFound is reused (0 = not cached)
+	StaleTTL    time.Duration // How long expired backends may be served while the inner resolver fails
+	MaxEntries  int
+}
+
+// Resolver caches the results of another resolver. Backends are reused for
+// TTL and "not found" answers for NegativeTTL. When the inner resolver fails
+// with any other error, backends up to StaleTTL old are served instead.
+// If the inner resolver is a core.CandidateResolver, only its lookup is cached
+// and the candidates are ordered anew on every resolution, so load balancing
+// and weights keep working on cache hits.
+type Resolver struct {
+	inner core.BackendResolver
+	opts  Options
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+type entry struct {
+	candidates core.CandidateSet
+	err        error // core.ErrBackendNotFound for negative entries
+	stored     time.Time
+}
+
+func NewResolver(inner core.BackendResolver, opts Options) *Resolver {
+	if opts.TTL <= 0 {
+		opts.TTL = 5 * time.Second
+	}
+	if opts.StaleTTL <= 0 {
+		opts.StaleTTL = 5 * time.Minute
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 10000
+	}
+	return &Resolver{
+		inner:   inner,
+		opts:    opts,
+		entries: make(map[string]*entry),
+	}
+}
+
+func (r *Resolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	key := cacheKey(metadata, databaseType)
+	now := time.Now()
+
+	r.mu.Lock()
+	cached := r.entries[key]
+	r.mu.Unlock()
+
+	if cached != nil {
+		age := now.Sub(cached.stored)
+		if cached.err == nil && age < r.opts.TTL {
+			metrics.ResolverCacheLookups.With(ResultHit).Inc()
+			return cached.candidates.Order(), nil
+		}
+		if cached.err != nil && age < r.opts.NegativeTTL {
+			metrics.ResolverCacheLookups.With(ResultNegativeHit).Inc()
+			return nil, cached.err
+		}
+	}
+
+	candidates, err := core.LookupCandidates(ctx, r.inner, metadata, databaseType)
+	switch {
+	case err == nil:
+		metrics.ResolverCacheLookups.With(ResultMiss).Inc()
+		r.store(key, &entry{candidates: candidates, stored: now})
+		return candidates.Order(), nil
+
+	case errors.Is(err, core.ErrBackendNotFound):
+		metrics.ResolverCacheLookups.With(ResultMiss).Inc()
+		if r.opts.NegativeTTL > 0 {
+			r.store(key, &entry{err: err, stored: now})
+		} else {
+			r.remove(key)
+		}
+		return nil, err
+
+	case cached != nil && cached.err == nil && now.Sub(cached.stored) < r.opts.StaleTTL:
+		metrics.ResolverCacheLookups.With(ResultStale).Inc()
+		logger.Warn("Resolution failed, serving stale backends",
+			"deployment_id", metadata["deployment_id"],
+			"age", now.Sub(cached.stored).Round(time.Millisecond),
+			"error", err)
+		return cached.candidates.Order(), nil
+
+	default:
+		metrics.ResolverCacheLookups.With(ResultMiss).Inc()
+		return nil, err
+	}
+}
+
+// store adds an entry, first dropping entries that can no longer be served and
+// then, if still full, arbitrary ones. Keys come from clients, so the size is bounded.
+func (r *Resolver) store(key string, e *entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.entries[key]; !ok && len(r.entries) >= r.opts.MaxEntries {
+		now := time.Now()
+		for k, old := range r.entries {
+			age := now.Sub(old.stored)
+			if (old.err != nil && age >= r.opts.NegativeTTL) || age >= r.opts.StaleTTL {
+				delete(r.entries, k)
+			}
+		}
+		for k := range r.entries {
+			if len(r.entries) < r.opts.MaxEntries {
+				break
+			}
+			delete(r.entries, k)
+		}
+	}
+	r.entries[key] = e
+}
+
+func (r *Resolver) remove(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, key)
+}
+
+// cacheKey covers what resolvers route on. Other startup parameters (user,
+// application_name, ...) are left out so they don't fragment the cache.
+func cacheKey(metadata core.RoutingMetadata, databaseType core.DatabaseType) string {
+	return strings.Join([]string{
+		string(databaseType),
+		metadata["deployment_id"],
+		metadata["pooled"],
+		metadata[core.MetadataTargetSessionAttrs],
+	}, "\x00")
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+)
+
+// rotatingResolver is a core.CandidateResolver whose candidates lead with a
+// different backend on every Order, like a round-robin balancer.
+type rotatingResolver struct {
+	addresses []string
+	lookups   atomic.Int32
+	err       error
+}
+
+type rotatingCandidates struct {
+	addresses []string
+	next      *atomic.Int32
+}
+
+func (c rotatingCandidates) Order() []core.Backend {
+	start := int(c.next.Add(1)-1) % len(c.addresses)
+	backends := make([]core.Backend, 0, len(c.addresses))
+	for i := range c.addresses {
+		backends = append(backends, core.Backend{Address: c.addresses[(start+i)%len(c.addresses)]})
+	}
+	return backends
+}
+
+func (r *rotatingResolver) Lookup(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) (core.CandidateSet, error) {
+	r.lookups.Add(1)
+	if r.err != nil {
+		return nil, r.err
+	}
+	return rotatingCandidates{addresses: r.addresses, next: &atomic.Int32{}}, nil
+}
+
+func (r *rotatingResolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	candidates, err := r.Lookup(ctx, metadata, databaseType)
+	if err != nil {
+		return nil, err
+	}
+	return candidates.Order(), nil
+}
+
+// fixedResolver only implements Resolve.
+type fixedResolver struct {
+	backends []core.Backend
+	resolves atomic.Int32
+}
+
+func (r *fixedResolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	r.resolves.Add(1)
+	return r.backends, nil
+}
+
+var testMetadata = core.RoutingMetadata{"deployment_id": "orders", "pooled": "false"}
+
+func firstAddress(t *testing.T, r *Resolver) string {
+	t.Helper()
+	backends, err := r.Resolve(context.Background(), testMetadata, core.DatabaseTypePostgresql)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	return backends[0].Address
+}
+
+func TestResolveOrdersCacheHits(t *testing.T) {
+	inner := &rotatingResolver{addresses: []string{"a:5432", "b:5432", "c:5432"}}
+	r := NewResolver(inner, Options{TTL: time.Minute})
+
+	var got []string
+	for range 4 {
+		got = append(got, firstAddress(t, r))
+	}
+	if want := []string{"a:5432", "b:5432", "c:5432", "a:5432"}; !slices.Equal(got, want) {
+		t.Errorf("first backends = %v, want %v", got, want)
+	}
+	if lookups := inner.lookups.Load(); lookups != 1 {
+		t.Errorf("inner lookups = %d, want 1", lookups)
+	}
+}
+
+func TestResolveOrdersStaleBackends(t *testing.T) {
+	inner := &rotatingResolver{addresses: []string{"a:5432", "b:5432"}}
+	r := NewResolver(inner, Options{TTL: time.Nanosecond, StaleTTL: time.Minute})
+
+	if first := firstAddress(t, r); first != "a:5432" {
+		t.Fatalf("first backend = %s, want a:5432", first)
+	}
+	time.Sleep(time.Millisecond)
+	inner.err = errors.New("discovery unavailable")
+	if first := firstAddress(t, r); first != "b:5432" {
+		t.Errorf("first stale backend = %s, want b:5432", first)
+	}
+}
+
+func TestResolveKeepsOrderOfPlainResolvers(t *testing.T) {
+	inner := &fixedResolver{backends: []core.Backend{{Address: "a:5432"}, {Address: "b:5432"}}}
+	r := NewResolver(inner, Options{TTL: time.Minute})
+
+	for range 3 {
+		if first := firstAddress(t, r); first != "a:5432" {
+			t.Errorf("first backend = %s, want a:5432", first)
+		}
+	}
+	if resolves := inner.resolves.Load(); resolves != 1 {
+		t.Errorf("inner resolves = %d, want 1", resolves)
+	}
+}
@@ -0,0 +1,55 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+)
+
+// Link is one resolver of a chain, named for errors and logs.
+type Link struct {
+	Name     string
+	Resolver core.BackendResolver
+}
+
+// Resolver tries its links in order and returns the first hit. A link that
+// reports core.ErrBackendNotFound passes the lookup on to the next one; any
+// other error stops the chain, so an outage of one source is never mistaken
+// for a miss and routed elsewhere.
+type Resolver struct {
+	links []Link
+}
+
+func NewResolver(links ...Link) (*Resolver, error) {
+	if len(links) == 0 {
+		return nil, fmt.Errorf("resolver chain is empty")
+	}
+	return &Resolver{links: links}, nil
+}
+
+func (r *Resolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	candidates, err := r.Lookup(ctx, metadata, databaseType)
+	if err != nil {
+		return nil, err
+	}
+	return candidates.Order(), nil
+}
+
+// Lookup returns the candidates of the first link that has the deployment.
+func (r *Resolver) Lookup(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) (core.CandidateSet, error) {
+	misses := make([]string, 0, len(r.links))
+	for _, link := range r.links {
+		candidates, err := core.LookupCandidates(ctx, link.Resolver, metadata, databaseType)
+		if err == nil {
+			return candidates, nil
+		}
+		if !errors.Is(err, core.ErrBackendNotFound) {
+			return nil, fmt.Errorf("%s discovery: %w", link.Name, err)
+		}
+		misses = append(misses, fmt.Sprintf("%s: %v", link.Name, err))
+	}
+	return nil, fmt.Errorf("%w in any discovery source (%s)", core.ErrBackendNotFound, strings.Join(misses, "; "))
+}
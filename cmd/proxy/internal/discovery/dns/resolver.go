@@ -0,0 +1,209 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+)
+
+// Options configure a Resolver. Zero values fall back to the defaults in NewResolver.
+type Options struct {
+	// Template maps a deployment ID and pool flag to the SRV name,
+	// e.g. "_postgres._tcp.{deployment}.{pool?}.db.internal".
+	Template *core.HostnameTemplate
+	Server   string        // DNS server as host:port; empty uses the system resolver
+	CacheTTL time.Duration // How long SRV answers are reused
+	Timeout  time.Duration // Timeout of a single lookup
+}
+
+// Resolver resolves deployments to backends through DNS SRV records.
+// Every target is a primary; replicas are not distinguished.
+type Resolver struct {
+	template *core.HostnameTemplate
+	dns      *net.Resolver
+	cacheTTL time.Duration
+	timeout  time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedSRV // by SRV name
+}
+
+type cachedSRV struct {
+	records []*net.SRV
+	expires time.Time
+}
+
+func NewResolver(opts Options) (*Resolver, error) {
+	if opts.Template == nil {
+		return nil, fmt.Errorf("DNS discovery requires an SRV name template")
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = 30 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+
+	dnsResolver := net.DefaultResolver
+	if opts.Server != "" {
+		if _, _, err := net.SplitHostPort(opts.Server); err != nil {
+			return nil, fmt.Errorf("invalid DNS server %q: %w", opts.Server, err)
+		}
+		// The pure Go resolver lets every query go to the configured server
+		dnsResolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, opts.Server)
+			},
+		}
+	}
+
+	return &Resolver{
+		template: opts.Template,
+		dns:      dnsResolver,
+		cacheTTL: opts.CacheTTL,
+		timeout:  opts.Timeout,
+		cache:    make(map[string]cachedSRV),
+	}, nil
+}
+
+func (r *Resolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	candidates, err := r.Lookup(ctx, metadata, databaseType)
+	if err != nil {
+		return nil, err
+	}
+	return candidates.Order(), nil
+}
+
+// Lookup returns the SRV records of the deployment, to be ordered by priority
+// and weight on every resolution.
+func (r *Resolver) Lookup(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) (core.CandidateSet, error) {
+	deploymentID, ok := metadata["deployment_id"]
+	if !ok {
+		return nil, fmt.Errorf("metadata missing 'deployment_id'")
+	}
+	attrs, err := core.ParseTargetSessionAttrs(metadata[core.MetadataTargetSessionAttrs])
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(attrs.Roles(), core.BackendRolePrimary) {
+		return nil, fmt.Errorf("%w: DNS discovery has no replicas for target_session_attrs=%s", core.ErrBackendNotFound, attrs)
+	}
+
+	name := r.template.Expand(deploymentID, metadata["pooled"] == "true")
+	records, err := r.lookup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return srvCandidates(records), nil
+}
+
+// srvCandidates are the SRV records of a deployment.
+type srvCandidates []*net.SRV
+
+// Order returns a backend per record in the order of orderSRV.
+func (c srvCandidates) Order() []core.Backend {
+	ordered := orderSRV(c)
+	backends := make([]core.Backend, 0, len(ordered))
+	for _, srv := range ordered {
+		host := strings.TrimSuffix(srv.Target, ".")
+		backends = append(backends, core.Backend{
+			Address: net.JoinHostPort(host, strconv.Itoa(int(srv.Port))),
+			Role:    core.BackendRolePrimary,
+		})
+	}
+	return backends
+}
+
+// lookup returns the SRV records for name, from the cache while they are fresh.
+func (r *Resolver) lookup(ctx context.Context, name string) ([]*net.SRV, error) {
+	now := time.Now()
+	r.mu.Lock()
+	cached, ok := r.cache[name]
+	r.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.records, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	_, records, err := r.dns.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return nil, fmt.Errorf("%w: no SRV records for %s", core.ErrBackendNotFound, name)
+		}
+		return nil, fmt.Errorf("SRV lookup for %s failed: %w", name, err)
+	}
+	// RFC 2782: a single "." target means the service is decidedly not available
+	if len(records) == 0 || (len(records) == 1 && records[0].Target == ".") {
+		return nil, fmt.Errorf("%w: no SRV records for %s", core.ErrBackendNotFound, name)
+	}
+
+	r.mu.Lock()
+	r.cache[name] = cachedSRV{records: records, expires: now.Add(r.cacheTTL)}
+	r.mu.Unlock()
+	logger.Info("DNS SRV records resolved", "name", name, "targets", len(records))
+	return records, nil
+}
+
+// orderSRV orders records by priority and, within a priority, by the weighted
+// random selection of RFC 2782, so cached answers are still spread by weight.
+func orderSRV(records []*net.SRV) []*net.SRV {
+	sorted := slices.Clone(records)
+	slices.SortStableFunc(sorted, func(a, b *net.SRV) int { return int(a.Priority) - int(b.Priority) })
+
+	ordered := make([]*net.SRV, 0, len(sorted))
+	for start := 0; start < len(sorted); {
+		end := start
+		for end < len(sorted) && sorted[end].Priority == sorted[start].Priority {
+			end++
+		}
+		ordered = append(ordered, shuffleByWeight(sorted[start:end])...)
+		start = end
+	}
+	return ordered
+}
+
+func shuffleByWeight(group []*net.SRV) []*net.SRV {
+	remaining := slices.Clone(group)
+	total := 0
+	for _, srv := range remaining {
+		total += int(srv.Weight)
+	}
+	if total == 0 {
+		rand.Shuffle(len(remaining), func(i, j int) { remaining[i], remaining[j] = remaining[j], remaining[i] })
+		return remaining
+	}
+
+	// As in RFC 2782, zero-weight records go first so they keep a small chance
+	// of being selected
+	slices.SortStableFunc(remaining, func(a, b *net.SRV) int {
+		return min(int(a.Weight), 1) - min(int(b.Weight), 1)
+	})
+	ordered := make([]*net.SRV, 0, len(remaining))
+	for len(remaining) > 0 {
+		n := rand.IntN(total + 1)
+		i := 0
+		for sum := 0; i < len(remaining)-1; i++ {
+			if sum += int(remaining[i].Weight); sum >= n {
+				break
+			}
+		}
+		total -= int(remaining[i].Weight)
+		ordered = append(ordered, remaining[i])
+		remaining = slices.Delete(remaining, i, i+1)
+	}
+	return ordered
+}
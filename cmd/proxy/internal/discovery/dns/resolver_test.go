@@ -0,0 +1,240 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// srvServer is a DNS server on a local UDP port that answers SRV queries from
+// a fixed table and NXDOMAIN for every other name.
+type srvServer struct {
+	conn    net.PacketConn
+	records map[string][]dnsmessage.SRVResource // by FQDN
+
+	mu      sync.Mutex
+	queries map[string]int // by FQDN
+}
+
+func newSRVServer(t *testing.T, records map[string][]dnsmessage.SRVResource) *srvServer {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &srvServer{conn: conn, records: records, queries: make(map[string]int)}
+	t.Cleanup(func() { conn.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *srvServer) addr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// queryCount returns how many queries reached the server for name.
+func (s *srvServer) queryCount(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[name+"."]
+}
+
+func (s *srvServer) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if response, err := s.answer(buf[:n]); err == nil {
+			s.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+func (s *srvServer) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+	name := question.Name.String()
+
+	s.mu.Lock()
+	s.queries[name]++
+	s.mu.Unlock()
+
+	records, ok := s.records[name]
+	header.Response, header.Authoritative, header.RecursionAvailable = true, true, true
+	if !ok {
+		header.RCode = dnsmessage.RCodeNameError
+	}
+
+	builder := dnsmessage.NewBuilder(nil, header)
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(question); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if question.Type == dnsmessage.TypeSRV {
+		for _, record := range records {
+			answerHeader := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}
+			if err := builder.SRVResource(answerHeader, record); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return builder.Finish()
+}
+
+func srv(priority, weight, port uint16, target string) dnsmessage.SRVResource {
+	return dnsmessage.SRVResource{
+		Priority: priority,
+		Weight:   weight,
+		Port:     port,
+		Target:   dnsmessage.MustNewName(target),
+	}
+}
+
+func newTestResolver(t *testing.T, server *srvServer, cacheTTL time.Duration) *Resolver {
+	t.Helper()
+	template, err := core.ParseHostnameTemplate("_postgres._tcp.{deployment}.db.test")
+	if err != nil {
+		t.Fatalf("parse template: %v", err)
+	}
+	r, err := NewResolver(Options{Template: template, Server: server.addr(), CacheTTL: cacheTTL})
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	return r
+}
+
+func resolve(r *Resolver, deploymentID string) ([]core.Backend, error) {
+	return r.Resolve(context.Background(), core.RoutingMetadata{"deployment_id": deploymentID, "pooled": "false"}, core.DatabaseTypePostgresql)
+}
+
+func addresses(backends []core.Backend) string {
+	list := make([]string, len(backends))
+	for i, backend := range backends {
+		list[i] = backend.Address
+	}
+	return strings.Join(list, ",")
+}
+
+func TestResolvePriorityOrder(t *testing.T) {
+	server := newSRVServer(t, map[string][]dnsmessage.SRVResource{
+		"_postgres._tcp.orders.db.test.": {
+			srv(30, 0, 5432, "c.db.test."),
+			srv(10, 0, 5432, "a.db.test."),
+			srv(20, 0, 5433, "b.db.test."),
+		},
+	})
+	r := newTestResolver(t, server, time.Minute)
+
+	backends, err := resolve(r, "orders")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if got, want := addresses(backends), "a.db.test:5432,b.db.test:5433,c.db.test:5432"; got != want {
+		t.Errorf("backends = %s, want %s", got, want)
+	}
+	for _, backend := range backends {
+		if backend.Role != core.BackendRolePrimary {
+			t.Errorf("backend %s has role %s, want primary", backend.Address, backend.Role)
+		}
+	}
+}
+
+func TestResolveWeightSpread(t *testing.T) {
+	server := newSRVServer(t, map[string][]dnsmessage.SRVResource{
+		"_postgres._tcp.orders.db.test.": {
+			srv(10, 90, 5432, "heavy.db.test."),
+			srv(10, 10, 5432, "light.db.test."),
+			srv(20, 50, 5432, "fallback.db.test."),
+		},
+	})
+	r := newTestResolver(t, server, time.Minute)
+
+	const rounds = 2000
+	first := make(map[string]int)
+	for range rounds {
+		backends, err := resolve(r, "orders")
+		if err != nil {
+			t.Fatalf("resolve: %v", err)
+		}
+		if len(backends) != 3 || backends[2].Address != "fallback.db.test:5432" {
+			t.Fatalf("backends = %s, want the lower priority target last", addresses(backends))
+		}
+		first[backends[0].Address]++
+	}
+
+	// The cached answer is re-ordered on every resolution: heavy should come
+	// first about 90% of the time
+	if heavy := first["heavy.db.test:5432"]; heavy < rounds*80/100 || heavy > rounds*97/100 {
+		t.Errorf("heavy target first in %d of %d resolutions, want about 90%%", heavy, rounds)
+	}
+	if first["light.db.test:5432"] == 0 {
+		t.Errorf("light target never picked first")
+	}
+	if got := server.queryCount("_postgres._tcp.orders.db.test"); got != 1 {
+		t.Errorf("server queried %d times, want 1 (answer cached)", got)
+	}
+}
+
+func TestResolveNotFound(t *testing.T) {
+	server := newSRVServer(t, map[string][]dnsmessage.SRVResource{
+		// RFC 2782: a single "." target means the service is decidedly not available
+		"_postgres._tcp.retired.db.test.": {srv(0, 0, 0, ".")},
+	})
+	r := newTestResolver(t, server, time.Minute)
+
+	for _, deploymentID := range []string{"missing", "retired"} {
+		t.Run(deploymentID, func(t *testing.T) {
+			_, err := resolve(r, deploymentID)
+			if !errors.Is(err, core.ErrBackendNotFound) {
+				t.Fatalf("resolve error = %v, want ErrBackendNotFound", err)
+			}
+		})
+	}
+}
+
+func TestResolveCacheExpiry(t *testing.T) {
+	const name = "_postgres._tcp.orders.db.test"
+	server := newSRVServer(t, map[string][]dnsmessage.SRVResource{
+		name + ".": {srv(10, 0, 5432, "a.db.test.")},
+	})
+	const ttl = 100 * time.Millisecond
+	r := newTestResolver(t, server, ttl)
+
+	for range 3 {
+		if _, err := resolve(r, "orders"); err != nil {
+			t.Fatalf("resolve: %v", err)
+		}
+	}
+	if got := server.queryCount(name); got != 1 {
+		t.Fatalf("server queried %d times within the TTL, want 1", got)
+	}
+
+	time.Sleep(ttl + 20*time.Millisecond)
+	if _, err := resolve(r, "orders"); err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if got := server.queryCount(name); got != 2 {
+		t.Errorf("server queried %d times after the TTL expired, want 2", got)
+	}
+}
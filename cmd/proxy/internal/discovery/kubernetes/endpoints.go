@@ -0,0 +1,90 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+)
+
+// serviceNameIndex indexes EndpointSlices by "namespace/service".
+const serviceNameIndex = "service"
+
+func indexByServiceName(obj interface{}) ([]string, error) {
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return nil, nil
+	}
+	name, ok := slice.Labels[discoveryv1.LabelServiceName]
+	if !ok {
+		return nil, nil
+	}
+	return []string{slice.Namespace + "/" + name}, nil
+}
+
+// podAddresses returns "ip:port" for every ready, non-terminating endpoint backing
+// the service port. The port is the target port published in the EndpointSlices.
+func (r *K8sResolver) podAddresses(svc *corev1.Service, servicePort corev1.ServicePort) ([]string, error) {
+	r.mu.RLock()
+	watch := r.watches[svc.Namespace]
+	if watch == nil {
+		watch = r.watches[""]
+	}
+	r.mu.RUnlock()
+	if watch == nil || watch.endpointSlices == nil {
+		return nil, fmt.Errorf("endpoint slices for service %s/%s are not watched", svc.Namespace, svc.Name)
+	}
+
+	objs, err := watch.endpointSlices.ByIndex(serviceNameIndex, svc.Namespace+"/"+svc.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	for _, obj := range objs {
+		slice, ok := obj.(*discoveryv1.EndpointSlice)
+		if !ok || slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+
+		// Slice ports carry the service port name and the resolved target port
+		var port int32
+		for _, slicePort := range slice.Ports {
+			if slicePort.Port != nil && slicePort.Name != nil && *slicePort.Name == servicePort.Name {
+				port = *slicePort.Port
+				break
+			}
+		}
+		if port == 0 {
+			continue
+		}
+
+		for _, endpoint := range slice.Endpoints {
+			if !endpointReady(endpoint) || len(endpoint.Addresses) == 0 {
+				continue
+			}
+			// All addresses of an endpoint belong to the same pod, the first one is enough
+			addresses = append(addresses, net.JoinHostPort(endpoint.Addresses[0], strconv.Itoa(int(port))))
+		}
+	}
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("service %s/%s has no ready endpoints", svc.Namespace, svc.Name)
+	}
+	// Stable order so round-robin cycles evenly
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// endpointReady reports whether an endpoint can take new connections.
+// An unknown ready condition counts as ready, as the EndpointSlice API recommends.
+func endpointReady(endpoint discoveryv1.Endpoint) bool {
+	conditions := endpoint.Conditions
+	if conditions.Terminating != nil && *conditions.Terminating {
+		return false
+	}
+	return conditions.Ready == nil || *conditions.Ready
+}
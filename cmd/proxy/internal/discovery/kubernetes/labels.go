@@ -0,0 +1,36 @@
+package kubernetes
+
+import "strings"
+
+// DefaultLabelPrefix is the prefix of the service labels read by the resolver.
+const DefaultLabelPrefix = "xdatabase-proxy"
+
+// labelKeys are the service label names for one prefix, so independent proxy
+// fleets can share a cluster by using different prefixes.
+type labelKeys struct {
+	enabled           string
+	databaseType      string
+	deploymentID      string
+	pooled            string
+	destinationPort   string
+	sendProxyProtocol string
+	backendSSLMode    string
+	role              string
+}
+
+func newLabelKeys(prefix string) labelKeys {
+	prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "-")
+	if prefix == "" {
+		prefix = DefaultLabelPrefix
+	}
+	return labelKeys{
+		enabled:           prefix + "-enabled",
+		databaseType:      prefix + "-database-type",
+		deploymentID:      prefix + "-deployment-id",
+		pooled:            prefix + "-pooled",
+		destinationPort:   prefix + "-destination-port",
+		sendProxyProtocol: prefix + "-send-proxy-protocol",
+		backendSSLMode:    prefix + "-backend-sslmode",
+		role:              prefix + "-role",
+	}
+}
@@ -0,0 +1,163 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+)
+
+// Precedence decides how a deployment found in several clusters is resolved.
+type Precedence string
+
+const (
+	PrecedenceFirst  Precedence = "first"  // The first cluster in order that has the deployment wins
+	PrecedenceMerge  Precedence = "merge"  // Candidates of all clusters, in cluster order within each role
+	PrecedenceReject Precedence = "reject" // A deployment found in several clusters fails to resolve
+)
+
+// Cluster is one watched cluster of a MultiClusterResolver.
+type Cluster struct {
+	Name     string
+	Resolver core.BackendResolver
+}
+
+// MultiClusterResolver merges the routes of several clusters into one table.
+// Clusters are queried in order; a cluster that reports core.ErrBackendNotFound
+// has no route for the deployment, any other error is a failure of that cluster.
+type MultiClusterResolver struct {
+	clusters   []Cluster
+	precedence Precedence
+}
+
+func NewMultiClusterResolver(precedence Precedence, clusters ...Cluster) (*MultiClusterResolver, error) {
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("no clusters to watch")
+	}
+	switch precedence {
+	case PrecedenceFirst, PrecedenceMerge, PrecedenceReject:
+	case "":
+		precedence = PrecedenceFirst
+	default:
+		return nil, fmt.Errorf("unknown cluster precedence: %s", precedence)
+	}
+	return &MultiClusterResolver{clusters: clusters, precedence: precedence}, nil
+}
+
+// Ready reports whether deployments can be resolved across the clusters whose
+// resolvers report readiness, matching how Lookup treats failing clusters.
+// With merge precedence one ready cluster is enough, as the others are only
+// skipped. With first precedence a failing cluster fails lookups instead of
+// passing them on, so no cluster before the first ready one may fail. With
+// reject every cluster must be ready, or a deployment defined twice would go
+// unnoticed.
+func (r *MultiClusterResolver) Ready() error {
+	var failures []string
+	for _, cluster := range r.clusters {
+		checker, ok := cluster.Resolver.(interface{ Ready() error })
+		if !ok {
+			continue
+		}
+		err := checker.Ready()
+		if err == nil {
+			if r.precedence != PrecedenceReject {
+				return nil
+			}
+			continue
+		}
+		failures = append(failures, fmt.Sprintf("cluster %s: %v", cluster.Name, err))
+		if r.precedence == PrecedenceFirst {
+			break
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+	if r.precedence == PrecedenceMerge {
+		return fmt.Errorf("no cluster is ready (%s)", strings.Join(failures, "; "))
+	}
+	return errors.New(strings.Join(failures, "; "))
+}
+
+func (r *MultiClusterResolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	candidates, err := r.Lookup(ctx, metadata, databaseType)
+	if err != nil {
+		return nil, err
+	}
+	return candidates.Order(), nil
+}
+
+// Lookup returns the candidates of the clusters that have the deployment, as
+// allowed by the precedence.
+func (r *MultiClusterResolver) Lookup(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) (core.CandidateSet, error) {
+	attrs, err := core.ParseTargetSessionAttrs(metadata[core.MetadataTargetSessionAttrs])
+	if err != nil {
+		return nil, err
+	}
+
+	var found []string
+	var sets []core.CandidateSet
+	var firstErr error
+	misses := make([]string, 0, len(r.clusters))
+	for _, cluster := range r.clusters {
+		candidates, err := core.LookupCandidates(ctx, cluster.Resolver, metadata, databaseType)
+		switch {
+		case err == nil:
+			if r.precedence == PrecedenceFirst {
+				return candidates, nil
+			}
+			found = append(found, cluster.Name)
+			sets = append(sets, candidates)
+
+		case errors.Is(err, core.ErrBackendNotFound):
+			misses = append(misses, fmt.Sprintf("%s: %v", cluster.Name, err))
+
+		default:
+			err = fmt.Errorf("cluster %s: %w", cluster.Name, err)
+			// A later cluster must not take over while an earlier one is failing
+			if r.precedence != PrecedenceMerge {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if r.precedence == PrecedenceReject && len(found) > 1 {
+		return nil, fmt.Errorf("deployment_id '%s' is defined in several clusters (%s)", metadata["deployment_id"], strings.Join(found, ", "))
+	}
+	switch len(sets) {
+	case 0:
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return nil, fmt.Errorf("%w in any cluster (%s)", core.ErrBackendNotFound, strings.Join(misses, "; "))
+	case 1:
+		return sets[0], nil
+	}
+	return mergedCandidates{roles: attrs.Roles(), sets: sets}, nil
+}
+
+// mergedCandidates are the candidates of several clusters, in cluster order.
+type mergedCandidates struct {
+	roles []core.BackendRole
+	sets  []core.CandidateSet
+}
+
+func (c mergedCandidates) Order() []core.Backend {
+	var candidates []core.Backend
+	for _, set := range c.sets {
+		candidates = append(candidates, set.Order()...)
+	}
+
+	// Each cluster orders its own candidates by role; keep that across clusters
+	slices.SortStableFunc(candidates, func(a, b core.Backend) int {
+		return slices.Index(c.roles, a.Role) - slices.Index(c.roles, b.Role)
+	})
+	return candidates
+}
@@ -0,0 +1,118 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+)
+
+// staticResolver is a cluster resolver with fixed backends per deployment, or
+// one that fails every lookup.
+type staticResolver struct {
+	deployments map[string][]core.Backend
+	err         error
+}
+
+func (r staticResolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	backends, ok := r.deployments[metadata["deployment_id"]]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", core.ErrBackendNotFound, metadata["deployment_id"])
+	}
+	return backends, nil
+}
+
+func TestMultiClusterLookup(t *testing.T) {
+	east := staticResolver{deployments: map[string][]core.Backend{
+		"orders": {
+			{Address: "10.0.0.1:5432", Cluster: "east", Role: core.BackendRolePrimary},
+			{Address: "10.0.0.2:5432", Cluster: "east", Role: core.BackendRoleReplica},
+		},
+	}}
+	west := staticResolver{deployments: map[string][]core.Backend{
+		"orders": {
+			{Address: "10.0.0.1:5432", Cluster: "west", Role: core.BackendRolePrimary},
+			{Address: "10.0.0.2:5432", Cluster: "west", Role: core.BackendRoleReplica},
+		},
+		"billing": {{Address: "10.0.1.1:5432", Cluster: "west", Role: core.BackendRolePrimary}},
+	}}
+	failing := staticResolver{err: errors.New("connection refused")}
+
+	tests := []struct {
+		name         string
+		precedence   Precedence
+		clusters     []staticResolver
+		deploymentID string
+		want         []string // Backend keys in order
+		wantNotFound bool
+		wantErr      bool
+	}{
+		{name: "first, in both", precedence: PrecedenceFirst, clusters: []staticResolver{east, west}, deploymentID: "orders",
+			want: []string{"east/10.0.0.1:5432", "east/10.0.0.2:5432"}},
+		{name: "first, only in second", precedence: PrecedenceFirst, clusters: []staticResolver{east, west}, deploymentID: "billing",
+			want: []string{"west/10.0.1.1:5432"}},
+		{name: "first, earlier cluster failing", precedence: PrecedenceFirst, clusters: []staticResolver{failing, west}, deploymentID: "orders",
+			wantErr: true},
+		{name: "first, later cluster failing", precedence: PrecedenceFirst, clusters: []staticResolver{east, failing}, deploymentID: "orders",
+			want: []string{"east/10.0.0.1:5432", "east/10.0.0.2:5432"}},
+		{name: "first, nowhere", precedence: PrecedenceFirst, clusters: []staticResolver{east, west}, deploymentID: "users",
+			wantNotFound: true},
+		{name: "merge, in both", precedence: PrecedenceMerge, clusters: []staticResolver{east, west}, deploymentID: "orders",
+			want: []string{"east/10.0.0.1:5432", "west/10.0.0.1:5432", "east/10.0.0.2:5432", "west/10.0.0.2:5432"}},
+		{name: "merge, only in second", precedence: PrecedenceMerge, clusters: []staticResolver{east, west}, deploymentID: "billing",
+			want: []string{"west/10.0.1.1:5432"}},
+		{name: "merge, earlier cluster failing", precedence: PrecedenceMerge, clusters: []staticResolver{failing, west}, deploymentID: "orders",
+			want: []string{"west/10.0.0.1:5432", "west/10.0.0.2:5432"}},
+		{name: "merge, failing and nowhere else", precedence: PrecedenceMerge, clusters: []staticResolver{failing, east}, deploymentID: "billing",
+			wantErr: true},
+		{name: "reject, in both", precedence: PrecedenceReject, clusters: []staticResolver{east, west}, deploymentID: "orders",
+			wantErr: true},
+		{name: "reject, only in second", precedence: PrecedenceReject, clusters: []staticResolver{east, west}, deploymentID: "billing",
+			want: []string{"west/10.0.1.1:5432"}},
+		{name: "reject, later cluster failing", precedence: PrecedenceReject, clusters: []staticResolver{west, failing}, deploymentID: "billing",
+			wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusters := make([]Cluster, len(tt.clusters))
+			for i, resolver := range tt.clusters {
+				clusters[i] = Cluster{Name: string(rune('a' + i)), Resolver: resolver}
+			}
+			r, err := NewMultiClusterResolver(tt.precedence, clusters...)
+			if err != nil {
+				t.Fatalf("new resolver: %v", err)
+			}
+
+			backends, err := r.Resolve(context.Background(), core.RoutingMetadata{"deployment_id": tt.deploymentID}, core.DatabaseTypePostgresql)
+			switch {
+			case tt.wantNotFound:
+				if !errors.Is(err, core.ErrBackendNotFound) {
+					t.Fatalf("Resolve() error = %v, want %v", err, core.ErrBackendNotFound)
+				}
+				return
+			case tt.wantErr:
+				if err == nil || errors.Is(err, core.ErrBackendNotFound) {
+					t.Fatalf("Resolve() error = %v, want a failure", err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("Resolve() failed: %v", err)
+			}
+
+			got := make([]string, len(backends))
+			for i, backend := range backends {
+				got[i] = backend.Key()
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("backends = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
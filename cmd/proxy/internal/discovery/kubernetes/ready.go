@@ -0,0 +1,90 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// informerHealth tracks whether an informer can list and watch its resources.
+// Informers keep serving their cache while the API server is unreachable, so
+// this is what tells a stale cache.
+type informerHealth struct {
+	mu           sync.Mutex
+	failingSince time.Time // Zero while the last list or watch call succeeded
+	lastErr      error
+}
+
+// record notes the outcome of a list or watch call and reports whether the
+// informer changed from succeeding to failing or back.
+func (h *informerHealth) record(err error) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		changed := !h.failingSince.IsZero()
+		h.failingSince, h.lastErr = time.Time{}, nil
+		return changed
+	}
+	changed := h.failingSince.IsZero()
+	if changed {
+		h.failingSince = time.Now()
+	}
+	h.lastErr = err
+	return changed
+}
+
+// stale returns an error if the informer has been failing for longer than timeout.
+func (h *informerHealth) stale(timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failingSince.IsZero() {
+		return nil
+	}
+	if age := time.Since(h.failingSince); age > timeout {
+		return fmt.Errorf("no successful list or watch for %s: %v", age.Round(time.Second), h.lastErr)
+	}
+	return nil
+}
+
+// Ready reports whether every informer has synced, including those of
+// namespaces picked up after startup, and none has failed to list or watch
+// for longer than the stale timeout.
+func (r *K8sResolver) Ready() error {
+	if r.namespacesSynced != nil {
+		if !r.namespacesSynced() {
+			return fmt.Errorf("namespace informer has not synced")
+		}
+		if err := r.namespaces.health.stale(r.staleTimeout); err != nil {
+			return fmt.Errorf("namespace informer is stale: %w", err)
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	namespaces := make([]string, 0, len(r.watches))
+	for namespace := range r.watches {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	for _, namespace := range namespaces {
+		watch := r.watches[namespace]
+		scope := "namespace " + namespace
+		if namespace == "" {
+			scope = "all namespaces"
+		}
+		if !watch.hasSynced() {
+			return fmt.Errorf("informer caches of %s have not synced", scope)
+		}
+		for _, informer := range watch.informers {
+			if err := informer.health.stale(r.staleTimeout); err != nil {
+				return fmt.Errorf("%s informer of %s is stale: %w", informer.resource, scope, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+)
+
+// readyResolver is a cluster resolver with a fixed readiness.
+type readyResolver struct {
+	err error
+}
+
+func (r readyResolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	return nil, core.ErrBackendNotFound
+}
+
+func (r readyResolver) Ready() error {
+	return r.err
+}
+
+func TestMultiClusterReady(t *testing.T) {
+	unreachable := readyResolver{err: errors.New("unreachable")}
+
+	tests := []struct {
+		name       string
+		precedence Precedence
+		clusters   []readyResolver
+		wantReady  bool
+	}{
+		{name: "first, all ready", precedence: PrecedenceFirst, clusters: []readyResolver{{}, {}}, wantReady: true},
+		{name: "first, first unreachable", precedence: PrecedenceFirst, clusters: []readyResolver{unreachable, {}}},
+		{name: "first, second unreachable", precedence: PrecedenceFirst, clusters: []readyResolver{{}, unreachable}, wantReady: true},
+		{name: "first, all unreachable", precedence: PrecedenceFirst, clusters: []readyResolver{unreachable, unreachable}},
+		{name: "merge, one unreachable", precedence: PrecedenceMerge, clusters: []readyResolver{{}, unreachable}, wantReady: true},
+		{name: "merge, all unreachable", precedence: PrecedenceMerge, clusters: []readyResolver{unreachable, unreachable}},
+		{name: "reject, all ready", precedence: PrecedenceReject, clusters: []readyResolver{{}, {}}, wantReady: true},
+		{name: "reject, one unreachable", precedence: PrecedenceReject, clusters: []readyResolver{{}, unreachable}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusters := make([]Cluster, len(tt.clusters))
+			for i, resolver := range tt.clusters {
+				clusters[i] = Cluster{Name: string(rune('a' + i)), Resolver: resolver}
+			}
+			r, err := NewMultiClusterResolver(tt.precedence, clusters...)
+			if err != nil {
+				t.Fatalf("new resolver: %v", err)
+			}
+			if err := r.Ready(); (err == nil) != tt.wantReady {
+				t.Errorf("Ready() = %v, want ready=%v", err, tt.wantReady)
+			}
+		})
+	}
+}
+
+func TestInformerHealthStale(t *testing.T) {
+	var health informerHealth
+	if health.record(nil) {
+		t.Errorf("first success reported as a change")
+	}
+	if !health.record(errors.New("connection refused")) {
+		t.Errorf("first failure not reported as a change")
+	}
+	if health.record(errors.New("connection refused")) {
+		t.Errorf("repeated failure reported as a change")
+	}
+
+	if err := health.stale(time.Hour); err != nil {
+		t.Errorf("stale within the timeout: %v", err)
+	}
+	if err := health.stale(0); err != nil {
+		t.Errorf("stale with staleness disabled: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := health.stale(time.Millisecond); err == nil {
+		t.Errorf("not stale after failing longer than the timeout")
+	}
+
+	if !health.record(nil) {
+		t.Errorf("recovery not reported as a change")
+	}
+	if err := health.stale(time.Millisecond); err != nil {
+		t.Errorf("stale after recovering: %v", err)
+	}
+}
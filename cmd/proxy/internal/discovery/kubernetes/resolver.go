@@ -3,82 +3,481 @@ package kubernetes
 import (
 	"context"
 	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/balancer"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/client-go/informers"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 )
 
+// routingIndex indexes services by their composite routing key, see routingKey.
+const routingIndex = "routing"
+
+// Options scope what the resolver watches. Without Namespaces or NamespaceSelector
+// services are watched cluster-wide.
+type Options struct {
+	Namespaces        []string // Watch only these namespaces
+	NamespaceSelector string   // Watch namespaces matching this label selector
+	LabelPrefix       string   // Service label prefix (default DefaultLabelPrefix)
+
+	Cluster       string // Cluster name for logs when several clusters are watched
+	ClusterDomain string // DNS domain of service addresses (default "cluster.local")
+
+	// RouteToPods resolves to ready pod IPs from EndpointSlices instead of the service DNS name
+	RouteToPods bool
+	Balancer    balancer.Strategy // Picks a pod when RouteToPods is set (default round-robin)
+
+	// Routes also watches XDatabaseRoute resources through Dynamic and writes their status
+	Routes  bool
+	Dynamic dynamic.Interface
+
+	SyncTimeout  time.Duration // Max wait for the initial informer sync (default 2m)
+	StaleTimeout time.Duration // Ready fails after an informer could not list or watch for this long (0 = never)
+}
+
 type K8sResolver struct {
-	store cache.Store
+	clientset     *kubernetes.Clientset
+	cluster       string
+	clusterDomain string
+	labels        labelKeys
+	routeToPods   bool
+	balancer      balancer.Strategy
+
+	dynamic      dynamic.Interface // nil unless routes are watched
+	routeChanged chan struct{}
+
+	syncTimeout  time.Duration
+	staleTimeout time.Duration
+
+	namespaces       trackedInformer      // Set with a namespace selector
+	namespacesSynced cache.InformerSynced // Also covers starting the initial watches
+
+	mu      sync.RWMutex
+	watches map[string]*serviceWatch // by namespace
 }
 
-func NewK8sResolver(clientset *kubernetes.Clientset) *K8sResolver {
-	factory := informers.NewSharedInformerFactory(clientset, 10*time.Minute)
-	serviceInformer := factory.Core().V1().Services().Informer()
+func NewK8sResolver(clientset *kubernetes.Clientset, opts Options) (*K8sResolver, error) {
+	r := &K8sResolver{
+		clientset:     clientset,
+		cluster:       opts.Cluster,
+		clusterDomain: opts.ClusterDomain,
+		labels:        newLabelKeys(opts.LabelPrefix),
+		routeToPods:   opts.RouteToPods,
+		balancer:      opts.Balancer,
+		syncTimeout:   opts.SyncTimeout,
+		staleTimeout:  opts.StaleTimeout,
+		watches:       make(map[string]*serviceWatch),
+	}
+	if r.clusterDomain == "" {
+		r.clusterDomain = "cluster.local"
+	}
+	if r.syncTimeout <= 0 {
+		r.syncTimeout = 2 * time.Minute
+	}
+	if r.balancer == nil {
+		r.balancer = &balancer.RoundRobin{}
+	}
+	if opts.Routes {
+		if opts.Dynamic == nil {
+			return nil, fmt.Errorf("watching XDatabaseRoutes requires a dynamic client")
+		}
+		r.dynamic = opts.Dynamic
+		r.routeChanged = make(chan struct{}, 1)
+	}
+
+	switch {
+	case opts.NamespaceSelector != "" && len(opts.Namespaces) > 0:
+		return nil, fmt.Errorf("namespaces and a namespace selector are mutually exclusive")
+	case opts.NamespaceSelector != "":
+		if err := r.watchNamespaceSelector(opts.NamespaceSelector); err != nil {
+			return nil, err
+		}
+	case len(opts.Namespaces) > 0:
+		for _, namespace := range opts.Namespaces {
+			r.startWatch(namespace)
+		}
+	default:
+		r.startWatch(metav1.NamespaceAll)
+	}
 
-	// Start the informer in the background
-	stopCh := make(chan struct{})
-	go factory.Start(stopCh)
-	factory.WaitForCacheSync(stopCh)
+	if err := r.waitForSync(); err != nil {
+		return nil, err
+	}
+	if r.dynamic != nil {
+		go r.runRouteStatus()
+	}
+	return r, nil
+}
 
-	return &K8sResolver{
-		store: serviceInformer.GetStore(),
+func (r *K8sResolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	candidates, err := r.Lookup(ctx, metadata, databaseType)
+	if err != nil {
+		return nil, err
 	}
+	return candidates.Order(), nil
 }
 
-func (r *K8sResolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) (string, error) {
+// Lookup returns the services or XDatabaseRoute targets of the deployment,
+// ordered by role and weight and balanced across pods on every resolution.
+func (r *K8sResolver) Lookup(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) (core.CandidateSet, error) {
 	deploymentID, ok := metadata["deployment_id"]
 	if !ok {
-		return "", fmt.Errorf("metadata missing 'deployment_id' (check connection string format: user.deployment_id[.pool])")
+		return nil, fmt.Errorf("metadata missing 'deployment_id' (check connection string format: user.deployment_id[.pool])")
 	}
 	pooled := metadata["pooled"] // "true" or "false"
+	key := routingKey(string(databaseType), deploymentID, pooled)
 
-	// Scan services for matching labels
-	for _, obj := range r.store.List() {
-		svc, ok := obj.(*corev1.Service)
-		if !ok {
-			continue
+	// XDatabaseRoutes take precedence over labelled services
+	if r.dynamic != nil {
+		routes, err := r.lookupRoutes(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(routes) > 0 {
+			attrs, err := core.ParseTargetSessionAttrs(metadata[core.MetadataTargetSessionAttrs])
+			if err != nil {
+				return nil, err
+			}
+			return r.resolveRoutes(routes, attrs)
 		}
+	}
 
-		labels := svc.Labels
-		if labels["xdatabase-proxy-enabled"] != "true" {
-			continue
+	services, err := r.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(services) == 0 {
+		return nil, fmt.Errorf("%w: service not found for deployment_id='%s', pooled='%s'", core.ErrBackendNotFound, deploymentID, pooled)
+	}
+
+	// If several services share a key, try them in the same order every time
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Namespace != services[j].Namespace {
+			return services[i].Namespace < services[j].Namespace
 		}
+		return services[i].Name < services[j].Name
+	})
+
+	attrs, err := core.ParseTargetSessionAttrs(metadata[core.MetadataTargetSessionAttrs])
+	if err != nil {
+		return nil, err
+	}
 
-		if labels["xdatabase-proxy-database-type"] != string(databaseType) {
+	// Misconfigured services are skipped as long as another candidate is usable
+	candidates := candidateSet{resolver: r, roles: attrs.Roles()}
+	var firstErr error
+	for _, svc := range r.orderByRole(services, attrs) {
+		group, err := r.serviceCandidates(svc)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
 			continue
 		}
+		candidates.groups = append(candidates.groups, group)
+	}
+
+	if len(candidates.groups) == 0 {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return nil, fmt.Errorf("%w: no service for deployment_id='%s', pooled='%s' matches target_session_attrs=%s", core.ErrBackendNotFound, deploymentID, pooled, attrs)
+	}
+	return candidates, nil
+}
+
+// candidateGroup holds the candidates of one service or route target, in address order.
+type candidateGroup struct {
+	role        core.BackendRole
+	weight      int // Share of first picks among groups of the same role (0 = keep order)
+	backends    []core.Backend
+	balancerKey string // Set when backends are pods, one of which the balancer picks
+}
+
+// candidateSet is the result of a lookup. Order picks among its groups and
+// pods anew on every call.
+type candidateSet struct {
+	resolver *K8sResolver
+	roles    []core.BackendRole // Most preferred first
+	groups   []candidateGroup   // In failover order within each role
+}
+
+// Order returns the candidates role by role. Within a role, a weighted pick of
+// the groups goes first and each group of pods leads with the balancer's pick.
+func (s candidateSet) Order() []core.Backend {
+	var ordered []core.Backend
+	for _, role := range s.roles {
+		var tier []candidateGroup
+		for _, group := range s.groups {
+			if group.role == role {
+				tier = append(tier, group)
+			}
+		}
+		for _, group := range pickWeightedGroup(tier) {
+			backends := group.backends
+			if group.balancerKey != "" {
+				backends = s.resolver.pickFirst(group.balancerKey, backends)
+			}
+			ordered = append(ordered, backends...)
+		}
+	}
+	return ordered
+}
+
+// empty reports whether no group has an acceptable role.
+func (s candidateSet) empty() bool {
+	for _, group := range s.groups {
+		if slices.Contains(s.roles, group.role) {
+			return false
+		}
+	}
+	return true
+}
+
+// pickWeightedGroup moves a weighted random pick to the front, keeping the
+// others in order as failover candidates. Groups without weights keep their order.
+func pickWeightedGroup(groups []candidateGroup) []candidateGroup {
+	weights := make([]int, len(groups))
+	for i, group := range groups {
+		weights[i] = group.weight
+	}
+	i := balancer.PickWeighted(weights)
+	if i < 0 {
+		return groups
+	}
+
+	ordered := make([]candidateGroup, 0, len(groups))
+	ordered = append(ordered, groups[i])
+	ordered = append(ordered, groups[:i]...)
+	return append(ordered, groups[i+1:]...)
+}
+
+// serviceCandidates returns the candidates for one service: its DNS name, or
+// its ready pods in address order with the balancer key to pick one by.
+func (r *K8sResolver) serviceCandidates(svc *corev1.Service) (candidateGroup, error) {
+	labels := svc.Labels
+
+	port, err := r.servicePort(svc)
+	if err != nil {
+		return candidateGroup{}, err
+	}
+	role, err := core.ParseBackendRole(labels[r.labels.role])
+	if err != nil {
+		return candidateGroup{}, err
+	}
+
+	backend := core.Backend{
+		Address: r.serviceAddress(svc, port),
+		Cluster: r.cluster,
+		Role:    role,
+	}
+
+	// Optional per-service override of the global BACKEND_PROXY_PROTOCOL setting
+	if value, ok := labels[r.labels.sendProxyProtocol]; ok {
+		sendProxyProtocol, err := strconv.ParseBool(value)
+		if err != nil {
+			logger.Warn("Ignoring invalid send-proxy-protocol label",
+				"label", r.labels.sendProxyProtocol, "service", svc.Namespace+"/"+svc.Name, "value", value)
+		} else {
+			backend.SendProxyProtocol = &sendProxyProtocol
+		}
+	}
+
+	// Optional per-service override of the global BACKEND_SSLMODE setting
+	if value, ok := labels[r.labels.backendSSLMode]; ok {
+		sslMode, err := core.ParseBackendSSLMode(value)
+		if err != nil {
+			logger.Warn("Ignoring invalid backend-sslmode label",
+				"label", r.labels.backendSSLMode, "service", svc.Namespace+"/"+svc.Name, "error", err)
+		} else {
+			backend.SSLMode = sslMode
+		}
+	}
+
+	if !r.routeToPods {
+		return candidateGroup{role: role, backends: []core.Backend{backend}}, nil
+	}
+
+	addresses, err := r.podAddresses(svc, port)
+	if err != nil {
+		return candidateGroup{}, err
+	}
+	return candidateGroup{role: role, backends: r.podBackends(svc, addresses, backend), balancerKey: r.balancerKey(svc)}, nil
+}
+
+// serviceAddress is the DNS address of a service port in the cluster domain.
+func (r *K8sResolver) serviceAddress(svc *corev1.Service, port corev1.ServicePort) string {
+	return net.JoinHostPort(r.serviceHost(svc), strconv.Itoa(int(port.Port)))
+}
+
+// serviceHost is the DNS name of a service in the cluster domain.
+func (r *K8sResolver) serviceHost(svc *corev1.Service) string {
+	return fmt.Sprintf("%s.%s.svc.%s", svc.Name, svc.Namespace, r.clusterDomain)
+}
+
+// balancerKey identifies the pods of a service to the balancer.
+func (r *K8sResolver) balancerKey(svc *corev1.Service) string {
+	return r.cluster + "/" + svc.Namespace + "/" + svc.Name
+}
+
+// podBackends returns a copy of backend per pod address of svc, in address
+// order. Pods serve the certificate of their service, so its DNS name is the
+// one verify-full checks.
+func (r *K8sResolver) podBackends(svc *corev1.Service, addresses []string, backend core.Backend) []core.Backend {
+	backend.ServerName = r.serviceHost(svc)
+	backends := make([]core.Backend, len(addresses))
+	for i, address := range addresses {
+		backend.Address = address
+		backends[i] = backend
+	}
+	return backends
+}
 
-		if labels["xdatabase-proxy-deployment-id"] == deploymentID &&
-			labels["xdatabase-proxy-pooled"] == pooled {
-
-			// Find the target port
-			// If xdatabase-proxy-destination-port label is set, use it to find the port in Spec
-			// Otherwise use the first port
-			var port int32
-			// We are ignoring the specific port value from label for now and just taking the first port
-			// In a more robust implementation, we should parse destPortStr and find the matching port in Spec
-			if _, ok := labels["xdatabase-proxy-destination-port"]; ok {
-				if len(svc.Spec.Ports) > 0 {
-					port = svc.Spec.Ports[0].Port
-				}
-			} else {
-				if len(svc.Spec.Ports) > 0 {
-					port = svc.Spec.Ports[0].Port
-				}
+// pickFirst moves the backend picked by the balancer to the front, keeping the others in order.
+// The balancer chooses among backend keys, which least-connections counts sessions by.
+func (r *K8sResolver) pickFirst(key string, backends []core.Backend) []core.Backend {
+	keys := make([]string, len(backends))
+	for i, backend := range backends {
+		keys[i] = backend.Key()
+	}
+	i := slices.Index(keys, r.balancer.Pick(key, keys))
+	if i <= 0 {
+		return backends
+	}
+
+	ordered := make([]core.Backend, 0, len(backends))
+	ordered = append(ordered, backends[i])
+	ordered = append(ordered, backends[:i]...)
+	return append(ordered, backends[i+1:]...)
+}
+
+// lookup returns the services indexed under key across all watched namespaces.
+// Namespaces still syncing are skipped; if nothing was found in the others,
+// the lookup fails with errNotSynced instead of reporting a miss.
+func (r *K8sResolver) lookup(key string) ([]*corev1.Service, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var services []*corev1.Service
+	var syncErr error
+	for namespace, watch := range r.watches {
+		if !watch.hasSynced() {
+			syncErr = errNotSynced(namespace)
+			continue
+		}
+		objs, err := watch.indexer.ByIndex(routingIndex, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			if svc, ok := obj.(*corev1.Service); ok {
+				services = append(services, svc)
 			}
+		}
+	}
+	if len(services) == 0 && syncErr != nil {
+		return nil, syncErr
+	}
+	return services, nil
+}
+
+// errNotSynced reports a namespace watched after startup whose informers are
+// still listing. It is not core.ErrBackendNotFound: the deployment may well be
+// there, so the miss must not be cached or passed on to another source.
+func errNotSynced(namespace string) error {
+	return fmt.Errorf("services in namespace %s have not synced yet", namespace)
+}
+
+// servicePort returns the port selected by the destination-port label, matched
+// against the service port number or name, or the first port without the label.
+func (r *K8sResolver) servicePort(svc *corev1.Service) (corev1.ServicePort, error) {
+	value, ok := svc.Labels[r.labels.destinationPort]
+	port, err := findServicePort(svc, value)
+	if err != nil && ok {
+		return port, fmt.Errorf("%w (label %s)", err, r.labels.destinationPort)
+	}
+	return port, err
+}
+
+// findServicePort returns the service port whose number or name is value,
+// or the first port if value is empty.
+func findServicePort(svc *corev1.Service, value string) (corev1.ServicePort, error) {
+	name := svc.Namespace + "/" + svc.Name
+	if len(svc.Spec.Ports) == 0 {
+		return corev1.ServicePort{}, fmt.Errorf("service %s has no ports", name)
+	}
+	if value == "" {
+		return svc.Spec.Ports[0], nil
+	}
 
-			if port == 0 {
-				continue
+	if number, err := strconv.ParseInt(value, 10, 32); err == nil {
+		for _, port := range svc.Spec.Ports {
+			if int64(port.Port) == number {
+				return port, nil
+			}
+		}
+	} else {
+		for _, port := range svc.Spec.Ports {
+			if port.Name == value {
+				return port, nil
 			}
+		}
+	}
+
+	return corev1.ServicePort{}, fmt.Errorf("service %s has no port matching %q", name, value)
+}
 
-			return fmt.Sprintf("%s.%s.svc.cluster.local:%d", svc.Name, svc.Namespace, port), nil
+// orderByRole returns the services acceptable for attrs, most preferred role first.
+// Services without a role label are primaries; services with an invalid one are skipped.
+func (r *K8sResolver) orderByRole(services []*corev1.Service, attrs core.TargetSessionAttrs) []*corev1.Service {
+	var ordered []*corev1.Service
+	for _, want := range attrs.Roles() {
+		for _, svc := range services {
+			if role, err := core.ParseBackendRole(svc.Labels[r.labels.role]); err == nil && role == want {
+				ordered = append(ordered, svc)
+			}
 		}
 	}
+	return ordered
+}
 
-	return "", fmt.Errorf("service not found for deployment_id='%s', pooled='%s'", deploymentID, pooled)
+func (r *K8sResolver) warnMisconfiguredService(obj interface{}) {
+	svc, ok := obj.(*corev1.Service)
+	if !ok || svc.Labels[r.labels.enabled] != "true" {
+		return
+	}
+	if _, err := r.servicePort(svc); err != nil {
+		logger.Warn("Service cannot be routed", "service", svc.Namespace+"/"+svc.Name, "error", err)
+	}
+	if _, err := core.ParseBackendRole(svc.Labels[r.labels.role]); err != nil {
+		logger.Warn("Service cannot be routed", "service", svc.Namespace+"/"+svc.Name, "label", r.labels.role, "error", err)
+	}
+}
+
+// routingKey is the composite lookup key: database type, deployment ID and pooled flag.
+func routingKey(databaseType, deploymentID, pooled string) string {
+	return databaseType + "/" + deploymentID + "/" + pooled
+}
+
+func (r *K8sResolver) indexByRoutingKey(obj interface{}) ([]string, error) {
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil, nil
+	}
+	labels := svc.Labels
+	if labels[r.labels.enabled] != "true" {
+		return nil, nil
+	}
+	return []string{routingKey(labels[r.labels.databaseType], labels[r.labels.deploymentID], labels[r.labels.pooled])}, nil
 }
@@ -0,0 +1,71 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/balancer"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// BenchmarkResolve resolves one deployment among a growing number of labelled
+// services. Lookups go through the routing index, so the cost should not grow
+// with the number of services.
+func BenchmarkResolve(b *testing.B) {
+	for _, n := range []int{10, 1000, 10000} {
+		b.Run(fmt.Sprintf("services=%d", n), func(b *testing.B) {
+			r := newBenchmarkResolver(b, n)
+			metadata := core.RoutingMetadata{"deployment_id": fmt.Sprintf("db-%d", n/2), "pooled": "false"}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				backends, err := r.Resolve(context.Background(), metadata, core.DatabaseTypePostgresql)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(backends) != 1 {
+					b.Fatalf("got %d backends, want 1", len(backends))
+				}
+			}
+		})
+	}
+}
+
+// newBenchmarkResolver returns a resolver over a fake indexer holding n
+// services, one per deployment, as the service informer would fill it.
+func newBenchmarkResolver(b *testing.B, n int) *K8sResolver {
+	b.Helper()
+	r := &K8sResolver{
+		clusterDomain: "cluster.local",
+		labels:        newLabelKeys(""),
+		balancer:      &balancer.RoundRobin{},
+		watches:       make(map[string]*serviceWatch),
+	}
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{routingIndex: r.indexByRoutingKey})
+	for i := 0; i < n; i++ {
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "databases",
+				Name:      fmt.Sprintf("db-%d", i),
+				Labels: map[string]string{
+					r.labels.enabled:      "true",
+					r.labels.databaseType: string(core.DatabaseTypePostgresql),
+					r.labels.deploymentID: fmt.Sprintf("db-%d", i),
+					r.labels.pooled:       "false",
+				},
+			},
+			Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "postgres", Port: 5432}}},
+		}
+		if err := indexer.Add(svc); err != nil {
+			b.Fatal(err)
+		}
+	}
+	r.watches[metav1.NamespaceAll] = &serviceWatch{indexer: indexer, hasSynced: func() bool { return true }}
+	return r
+}
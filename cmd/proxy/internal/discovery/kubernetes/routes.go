@@ -0,0 +1,344 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// RouteResource is the XDatabaseRoute custom resource, see kubernetes/crds/xdatabaseroute.yaml.
+var RouteResource = schema.GroupVersionResource{Group: "xdatabase-proxy.io", Version: "v1alpha1", Resource: "xdatabaseroutes"}
+
+// routeStatusInterval is how often route statuses are re-evaluated, so they
+// follow services and endpoints that come and go.
+const routeStatusInterval = 30 * time.Second
+
+// Route phases written to the status of XDatabaseRoutes.
+const (
+	RoutePhaseResolved       = "Resolved"
+	RoutePhaseBackendMissing = "BackendMissing"
+	RoutePhaseInvalid        = "Invalid"
+)
+
+// xdatabaseRoute routes a deployment to services in its own namespace.
+type xdatabaseRoute struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              routeSpec   `json:"spec"`
+	Status            routeStatus `json:"status,omitempty"`
+}
+
+type routeSpec struct {
+	DeploymentID      string        `json:"deploymentId"`
+	Pooled            bool          `json:"pooled,omitempty"`
+	DatabaseType      string        `json:"databaseType"`
+	BackendSSLMode    string        `json:"backendSSLMode,omitempty"`
+	SendProxyProtocol *bool         `json:"sendProxyProtocol,omitempty"`
+	AllowedCIDRs      []string      `json:"allowedCIDRs,omitempty"` // Client addresses allowed to connect (empty = any)
+	Targets           []routeTarget `json:"targets"`
+}
+
+type routeTarget struct {
+	Service string             `json:"service"`
+	Port    intstr.IntOrString `json:"port,omitempty"` // Service port number or name (default: first port)
+	Role    string             `json:"role,omitempty"`
+	Weight  int                `json:"weight,omitempty"` // Share of first picks among targets of the same role
+}
+
+type routeStatus struct {
+	Phase              string `json:"phase,omitempty"`
+	Message            string `json:"message,omitempty"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+}
+
+func parseRoute(obj interface{}) (*xdatabaseRoute, bool) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, false
+	}
+	var route xdatabaseRoute
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &route); err != nil {
+		logger.Warn("Ignoring malformed XDatabaseRoute", "route", u.GetNamespace()+"/"+u.GetName(), "error", err)
+		return nil, false
+	}
+	return &route, true
+}
+
+func indexRouteByRoutingKey(obj interface{}) ([]string, error) {
+	route, ok := parseRoute(obj)
+	if !ok {
+		return nil, nil
+	}
+	spec := route.Spec
+	return []string{routingKey(spec.DatabaseType, spec.DeploymentID, fmt.Sprint(spec.Pooled))}, nil
+}
+
+// lookupRoutes returns the XDatabaseRoutes indexed under key across all watched
+// namespaces, in namespace/name order. Like lookup, it fails with errNotSynced
+// rather than finding nothing while a namespace is still syncing.
+func (r *K8sResolver) lookupRoutes(key string) ([]*xdatabaseRoute, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var routes []*xdatabaseRoute
+	var syncErr error
+	for namespace, watch := range r.watches {
+		if watch.routes == nil {
+			continue
+		}
+		if !watch.hasSynced() {
+			syncErr = errNotSynced(namespace)
+			continue
+		}
+		objs, err := watch.routes.ByIndex(routingIndex, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objs {
+			if route, ok := parseRoute(obj); ok {
+				routes = append(routes, route)
+			}
+		}
+	}
+	if len(routes) == 0 && syncErr != nil {
+		return nil, syncErr
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Namespace != routes[j].Namespace {
+			return routes[i].Namespace < routes[j].Namespace
+		}
+		return routes[i].Name < routes[j].Name
+	})
+	return routes, nil
+}
+
+// resolveRoutes returns the candidates of the routes for attrs: most preferred
+// role first and, within a role, a weighted pick of the targets first.
+// Targets that cannot be resolved are skipped as long as another one is usable.
+func (r *K8sResolver) resolveRoutes(routes []*xdatabaseRoute, attrs core.TargetSessionAttrs) (core.CandidateSet, error) {
+	candidates := candidateSet{resolver: r, roles: attrs.Roles()}
+	var firstErr error
+	for _, route := range routes {
+		resolved, errs, err := r.resolveRoute(route)
+		if err == nil && len(errs) > 0 {
+			err = errs[0]
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("XDatabaseRoute %s/%s: %w", route.Namespace, route.Name, err)
+		}
+		candidates.groups = append(candidates.groups, resolved...)
+	}
+
+	if candidates.empty() {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return nil, fmt.Errorf("%w: no XDatabaseRoute target matches target_session_attrs=%s", core.ErrBackendNotFound, attrs)
+	}
+	return candidates, nil
+}
+
+// resolveRoute resolves every target of a route without picking among its
+// backends, so computing a status leaves the balancer alone. err reports an
+// invalid spec (no target is used then), targetErrs the targets that could not be resolved.
+func (r *K8sResolver) resolveRoute(route *xdatabaseRoute) (resolved []candidateGroup, targetErrs []error, err error) {
+	spec := route.Spec
+	if len(spec.Targets) == 0 {
+		return nil, nil, fmt.Errorf("no targets")
+	}
+
+	template := core.Backend{Cluster: r.cluster, SendProxyProtocol: spec.SendProxyProtocol}
+	if spec.BackendSSLMode != "" {
+		if template.SSLMode, err = core.ParseBackendSSLMode(spec.BackendSSLMode); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, cidr := range spec.AllowedCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid allowedCIDRs entry: %w", err)
+		}
+		template.AllowedSources = append(template.AllowedSources, prefix.Masked())
+	}
+
+	roles := make([]core.BackendRole, len(spec.Targets))
+	for i, target := range spec.Targets {
+		if roles[i], err = core.ParseBackendRole(target.Role); err != nil {
+			return nil, nil, fmt.Errorf("targets[%d]: %w", i, err)
+		}
+	}
+
+	for i, target := range spec.Targets {
+		template.Role = roles[i]
+		backends, key, err := r.targetBackends(route.Namespace, target, template)
+		if err != nil {
+			targetErrs = append(targetErrs, fmt.Errorf("targets[%d]: %w", i, err))
+			continue
+		}
+		resolved = append(resolved, candidateGroup{role: roles[i], weight: target.Weight, backends: backends, balancerKey: key})
+	}
+	return resolved, targetErrs, nil
+}
+
+// targetBackends returns the candidates of one route target: its service DNS
+// name, or its ready pods in address order with the balancer key to pick one by.
+func (r *K8sResolver) targetBackends(namespace string, target routeTarget, template core.Backend) ([]core.Backend, string, error) {
+	svc, err := r.targetService(namespace, target.Service)
+	if err != nil {
+		return nil, "", err
+	}
+
+	value := ""
+	if target.Port.Type == intstr.String || target.Port.IntVal != 0 {
+		value = target.Port.String()
+	}
+	port, err := findServicePort(svc, value)
+	if err != nil {
+		return nil, "", err
+	}
+
+	backend := template
+	backend.Address = r.serviceAddress(svc, port)
+	if !r.routeToPods {
+		return []core.Backend{backend}, "", nil
+	}
+
+	addresses, err := r.podAddresses(svc, port)
+	if err != nil {
+		return nil, "", err
+	}
+	return r.podBackends(svc, addresses, backend), r.balancerKey(svc), nil
+}
+
+// errServiceMissing marks targets whose service does not exist.
+var errServiceMissing = errors.New("service not found")
+
+// targetService looks a route target up among all services of the namespace,
+// labelled for the proxy or not.
+func (r *K8sResolver) targetService(namespace, name string) (*corev1.Service, error) {
+	r.mu.RLock()
+	watch := r.watches[namespace]
+	if watch == nil {
+		watch = r.watches[metav1.NamespaceAll]
+	}
+	r.mu.RUnlock()
+	if watch == nil || watch.services == nil {
+		return nil, fmt.Errorf("services in namespace %s are not watched", namespace)
+	}
+	if !watch.hasSynced() {
+		return nil, errNotSynced(namespace)
+	}
+
+	obj, exists, err := watch.services.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	svc, ok := obj.(*corev1.Service)
+	if !exists || !ok {
+		return nil, fmt.Errorf("%w: %s/%s", errServiceMissing, namespace, name)
+	}
+	return svc, nil
+}
+
+// runRouteStatus keeps the status of every watched XDatabaseRoute up to date
+// until the process exits. Each proxy replica computes the same status, so only
+// actual changes are written.
+func (r *K8sResolver) runRouteStatus() {
+	ticker := time.NewTicker(routeStatusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.routeChanged:
+		case <-ticker.C:
+		}
+		r.updateRouteStatuses()
+	}
+}
+
+// routeEvent schedules a status update after a route was added or changed.
+func (r *K8sResolver) routeEvent() {
+	select {
+	case r.routeChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (r *K8sResolver) updateRouteStatuses() {
+	r.mu.RLock()
+	var objs []interface{}
+	for _, watch := range r.watches {
+		// Targets in a namespace still syncing would be reported missing
+		if watch.routes != nil && watch.hasSynced() {
+			objs = append(objs, watch.routes.List()...)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, obj := range objs {
+		route, ok := parseRoute(obj)
+		if !ok {
+			continue
+		}
+		status := r.routeStatus(route)
+		if status == route.Status {
+			continue
+		}
+		if err := r.writeRouteStatus(obj.(*unstructured.Unstructured), status); err != nil {
+			logger.Warn("Failed to update XDatabaseRoute status", "route", route.Namespace+"/"+route.Name, "error", err)
+			continue
+		}
+		if status.Phase != route.Status.Phase {
+			logger.Info("XDatabaseRoute status changed", "route", route.Namespace+"/"+route.Name, "phase", status.Phase, "message", status.Message)
+		}
+	}
+}
+
+func (r *K8sResolver) routeStatus(route *xdatabaseRoute) routeStatus {
+	status := routeStatus{ObservedGeneration: route.Generation}
+
+	resolved, targetErrs, err := r.resolveRoute(route)
+	switch {
+	case err != nil:
+		status.Phase, status.Message = RoutePhaseInvalid, err.Error()
+	case len(targetErrs) > 0:
+		messages := make([]string, len(targetErrs))
+		for i, targetErr := range targetErrs {
+			messages[i] = targetErr.Error()
+		}
+		status.Phase = RoutePhaseBackendMissing
+		status.Message = fmt.Sprintf("%d of %d targets unavailable: %s", len(targetErrs), len(route.Spec.Targets), strings.Join(messages, "; "))
+	default:
+		status.Phase = RoutePhaseResolved
+		status.Message = fmt.Sprintf("%d targets resolved", len(resolved))
+	}
+	return status
+}
+
+func (r *K8sResolver) writeRouteStatus(obj *unstructured.Unstructured, status routeStatus) error {
+	statusObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+	obj = obj.DeepCopy()
+	if err := unstructured.SetNestedMap(obj.Object, statusObj, "status"); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = r.dynamic.Resource(RouteResource).Namespace(obj.GetNamespace()).UpdateStatus(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
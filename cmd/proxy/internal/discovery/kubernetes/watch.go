@@ -0,0 +1,270 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	apiwatch "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+const informerResync = 10 * time.Minute
+
+// serviceWatch is the service (and EndpointSlice) informer of one namespace (or of the whole cluster).
+type serviceWatch struct {
+	indexer        cache.Indexer
+	endpointSlices cache.Indexer // nil unless routing to pods
+	routes         cache.Indexer // XDatabaseRoutes, nil unless watched
+	services       cache.Indexer // All services, labelled or not; nil unless routes are watched
+	informers      []trackedInformer
+	hasSynced      cache.InformerSynced
+	stopCh         chan struct{}
+}
+
+// trackedInformer is an informer together with the outcome of its list and watch calls.
+type trackedInformer struct {
+	cache.SharedIndexInformer
+	resource string
+	health   *informerHealth
+}
+
+// startWatch starts a service informer for the namespace unless one is already running.
+// metav1.NamespaceAll watches every namespace.
+func (r *K8sResolver) startWatch(namespace string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.watches[namespace]; ok {
+		return
+	}
+
+	// Only services opted in to the proxy are listed and cached
+	enabledOnly := func(options *metav1.ListOptions) {
+		options.LabelSelector = r.labels.enabled + "=true"
+	}
+	services := r.clientset.CoreV1().Services(namespace)
+	serviceInformer := r.newInformer("services", namespace, listWatch(services.List, services.Watch, enabledOnly), &corev1.Service{})
+
+	// Index by (database-type, deployment-id, pooled) so lookups don't scan every service
+	if err := serviceInformer.AddIndexers(cache.Indexers{routingIndex: r.indexByRoutingKey}); err != nil {
+		logger.Error("Failed to add service indexer", "namespace", namespace, "error", err)
+	}
+
+	// Surface misconfigured services when they are synced rather than on the first connection
+	serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    r.warnMisconfiguredService,
+		UpdateFunc: func(_, obj interface{}) { r.warnMisconfiguredService(obj) },
+	})
+
+	watch := &serviceWatch{indexer: serviceInformer.GetIndexer(), informers: []trackedInformer{serviceInformer}}
+
+	// XDatabaseRoutes may target any service of their namespace, so services and
+	// EndpointSlices are then watched without the label selector
+	sliceFilter := enabledOnly
+	if r.dynamic != nil {
+		sliceFilter = nil
+		allServices := r.newInformer("services", namespace, listWatch(services.List, services.Watch, nil), &corev1.Service{})
+		allServices.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { r.routeEvent() },
+			UpdateFunc: func(_, _ interface{}) { r.routeEvent() },
+			DeleteFunc: func(interface{}) { r.routeEvent() },
+		})
+		watch.services = allServices.GetIndexer()
+		watch.informers = append(watch.informers, allServices)
+
+		routes := r.dynamic.Resource(RouteResource).Namespace(namespace)
+		routeInformer := r.newInformer(RouteResource.Resource, namespace, listWatch(routes.List, routes.Watch, nil), &unstructured.Unstructured{})
+		if err := routeInformer.AddIndexers(cache.Indexers{routingIndex: indexRouteByRoutingKey}); err != nil {
+			logger.Error("Failed to add XDatabaseRoute indexer", "namespace", namespace, "error", err)
+		}
+		routeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { r.routeEvent() },
+			UpdateFunc: func(_, _ interface{}) { r.routeEvent() },
+		})
+		watch.routes = routeInformer.GetIndexer()
+		watch.informers = append(watch.informers, routeInformer)
+	}
+
+	// EndpointSlices carry the labels of their service, so the same selector applies
+	// (none when routes are watched)
+	if r.routeToPods {
+		slices := r.clientset.DiscoveryV1().EndpointSlices(namespace)
+		sliceInformer := r.newInformer("endpointslices", namespace, listWatch(slices.List, slices.Watch, sliceFilter), &discoveryv1.EndpointSlice{})
+		if err := sliceInformer.AddIndexers(cache.Indexers{serviceNameIndex: indexByServiceName}); err != nil {
+			logger.Error("Failed to add EndpointSlice indexer", "namespace", namespace, "error", err)
+		}
+		watch.endpointSlices = sliceInformer.GetIndexer()
+		watch.informers = append(watch.informers, sliceInformer)
+	}
+
+	watch.hasSynced = func() bool {
+		for _, informer := range watch.informers {
+			if !informer.HasSynced() {
+				return false
+			}
+		}
+		return true
+	}
+
+	watch.stopCh = make(chan struct{})
+	for _, informer := range watch.informers {
+		go informer.Run(watch.stopCh)
+	}
+	r.watches[namespace] = watch
+	if namespace == metav1.NamespaceAll {
+		logger.Info("Watching services in all namespaces", "cluster", r.cluster)
+	} else {
+		logger.Info("Watching services", "cluster", r.cluster, "namespace", namespace)
+	}
+}
+
+// newInformer creates an informer whose list and watch calls are tracked for Ready.
+func (r *K8sResolver) newInformer(resource, namespace string, lw *cache.ListWatch, obj runtime.Object) trackedInformer {
+	health := &informerHealth{}
+	record := func(err error) {
+		if !health.record(err) {
+			return
+		}
+		if err != nil {
+			logger.Warn("Kubernetes API server unreachable, serving cached data",
+				"cluster", r.cluster, "namespace", namespace, "resource", resource, "error", err)
+		} else {
+			logger.Info("Kubernetes API server reachable again", "cluster", r.cluster, "namespace", namespace, "resource", resource)
+		}
+	}
+	tracked := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			obj, err := lw.List(options)
+			record(err)
+			return obj, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (apiwatch.Interface, error) {
+			w, err := lw.Watch(options)
+			record(err)
+			return w, err
+		},
+	}
+	return trackedInformer{
+		SharedIndexInformer: cache.NewSharedIndexInformer(tracked, obj, informerResync, cache.Indexers{}),
+		resource:            resource,
+		health:              health,
+	}
+}
+
+// listWatch adapts the List and Watch methods of a typed or dynamic client,
+// applying tweak (if any) to the list options of both.
+func listWatch[T runtime.Object](
+	list func(context.Context, metav1.ListOptions) (T, error),
+	watch func(context.Context, metav1.ListOptions) (apiwatch.Interface, error),
+	tweak func(*metav1.ListOptions),
+) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			if tweak != nil {
+				tweak(&options)
+			}
+			return list(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (apiwatch.Interface, error) {
+			if tweak != nil {
+				tweak(&options)
+			}
+			return watch(context.Background(), options)
+		},
+	}
+}
+
+// stopWatch stops the service informer of a namespace and drops its services.
+func (r *K8sResolver) stopWatch(namespace string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	watch, ok := r.watches[namespace]
+	if !ok {
+		return
+	}
+	close(watch.stopCh)
+	delete(r.watches, namespace)
+	logger.Info("Stopped watching services", "cluster", r.cluster, "namespace", namespace)
+}
+
+// waitForSync blocks until every running service informer has synced, for at most the sync timeout.
+func (r *K8sResolver) waitForSync() error {
+	r.mu.RLock()
+	synced := make([]cache.InformerSynced, 0, len(r.watches))
+	for _, watch := range r.watches {
+		synced = append(synced, watch.hasSynced)
+	}
+	r.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.syncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("informer caches did not sync within %s", r.syncTimeout)
+	}
+	return nil
+}
+
+// watchNamespaceSelector starts and stops per-namespace service informers as
+// namespaces matching the label selector appear and disappear.
+func (r *K8sResolver) watchNamespaceSelector(selector string) error {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return fmt.Errorf("invalid namespace selector %q: %w", selector, err)
+	}
+
+	namespaces := r.clientset.CoreV1().Namespaces()
+	namespaceInformer := r.newInformer("namespaces", metav1.NamespaceAll, listWatch(namespaces.List, namespaces.Watch, func(options *metav1.ListOptions) {
+		options.LabelSelector = parsed.String()
+	}), &corev1.Namespace{})
+
+	registration, err := namespaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if ns, ok := obj.(*corev1.Namespace); ok {
+				r.startWatch(ns.Name)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			ns, ok := obj.(*corev1.Namespace)
+			if !ok {
+				return
+			}
+			if parsed.Matches(labels.Set(ns.Labels)) {
+				r.startWatch(ns.Name)
+			} else {
+				r.stopWatch(ns.Name)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if ns, ok := obj.(*corev1.Namespace); ok {
+				r.stopWatch(ns.Name)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch namespaces: %w", err)
+	}
+
+	r.namespaces, r.namespacesSynced = namespaceInformer, registration.HasSynced
+
+	// Runs for the lifetime of the process, like the service informers
+	go namespaceInformer.Run(make(chan struct{}))
+	logger.Info("Watching namespaces", "selector", parsed.String())
+
+	// Wait until the initial namespaces have been handled so their service informers exist
+	ctx, cancel := context.WithTimeout(context.Background(), r.syncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), registration.HasSynced) {
+		return fmt.Errorf("namespace informer did not sync within %s", r.syncTimeout)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+)
+
+// CancelKeyStore is a process-local core.CancelKeyStore.
+// Cancel requests only reach the right backend if they land on the same proxy instance.
+type CancelKeyStore struct {
+	keys map[core.CancelKey]core.Backend
+	mu   sync.RWMutex
+}
+
+func NewCancelKeyStore() *CancelKeyStore {
+	return &CancelKeyStore{keys: make(map[core.CancelKey]core.Backend)}
+}
+
+func (s *CancelKeyStore) Register(ctx context.Context, key core.CancelKey, backend core.Backend) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = backend
+	return nil
+}
+
+func (s *CancelKeyStore) Lookup(ctx context.Context, key core.CancelKey) (core.Backend, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	backend, ok := s.keys[key]
+	return backend, ok, nil
+}
+
+func (s *CancelKeyStore) Unregister(ctx context.Context, key core.CancelKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+	return nil
+}
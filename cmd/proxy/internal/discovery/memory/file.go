@@ -0,0 +1,215 @@
+package memory
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/metrics"
+	"sigs.k8s.io/yaml"
+)
+
+// BackendsFile is the format of STATIC_BACKENDS_FILE, in YAML or JSON.
+//
+//	backends:
+//	  - deployment_id: db1
+//	    database_type: postgresql
+//	    addresses: ["10.0.0.1:5432", "10.0.0.2:5432"]
+//	  - deployment_id: db1
+//	    role: replica
+//	    sslmode: require
+//	    addresses:
+//	      - {address: "10.0.1.1:5432", weight: 3}
+//	      - {address: "10.0.1.2:5432", weight: 1}
+type BackendsFile struct {
+	Backends []FileBackend `json:"backends"`
+}
+
+// FileBackend is one deployment (and role) with its addresses.
+type FileBackend struct {
+	DeploymentID  string        `json:"deployment_id"`
+	Pooled        bool          `json:"pooled,omitempty"`
+	DatabaseType  string        `json:"database_type,omitempty"` // Empty matches every database type
+	Role          string        `json:"role,omitempty"`
+	ProxyProtocol *bool         `json:"proxy_protocol,omitempty"`
+	SSLMode       string        `json:"sslmode,omitempty"`
+	Addresses     []FileAddress `json:"addresses"`
+}
+
+// plain is FileAddress without its custom JSON methods.
+type plain FileAddress
+
+// FileAddress is a backend address, written either as "host:port" or as
+// {address, weight}.
+type FileAddress struct {
+	Address string `json:"address"`
+	Weight  int    `json:"weight,omitempty"`
+}
+
+func (a *FileAddress) UnmarshalJSON(data []byte) error {
+	var address string
+	if err := json.Unmarshal(data, &address); err == nil {
+		*a = FileAddress{Address: address}
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*plain)(a))
+}
+
+// MarshalJSON writes unweighted addresses as plain strings.
+func (a FileAddress) MarshalJSON() ([]byte, error) {
+	if a.Weight == 0 {
+		return json.Marshal(a.Address)
+	}
+	return json.Marshal(plain(a))
+}
+
+// ParseBackendsFile parses and validates a backends file into routes by RouteKey.
+// Unknown fields are rejected so typos don't silently change routing.
+func ParseBackendsFile(data []byte) (map[string][]Route, error) {
+	var file BackendsFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid backends file: %w", err)
+	}
+
+	routes := make(map[string][]Route)
+	for i, entry := range file.Backends {
+		if err := entry.appendRoutes(routes); err != nil {
+			return nil, fmt.Errorf("backends[%d]: %w", i, err)
+		}
+	}
+	return routes, nil
+}
+
+func (b FileBackend) appendRoutes(routes map[string][]Route) error {
+	if b.DeploymentID == "" {
+		return fmt.Errorf("deployment_id is required")
+	}
+	if strings.Contains(b.DeploymentID, ".") {
+		return fmt.Errorf("invalid deployment_id %q: must not contain '.'", b.DeploymentID)
+	}
+	if len(b.Addresses) == 0 {
+		return fmt.Errorf("no addresses for deployment %s", b.DeploymentID)
+	}
+
+	databaseType := core.DatabaseType(b.DatabaseType)
+	switch databaseType {
+	case "", core.DatabaseTypePostgresql, core.DatabaseTypeMysql, core.DatabaseTypeScylla:
+	default:
+		return fmt.Errorf("unsupported database_type: %s (supported: postgresql, mysql, scylla)", b.DatabaseType)
+	}
+	role, err := core.ParseBackendRole(b.Role)
+	if err != nil {
+		return err
+	}
+	var sslMode core.BackendSSLMode
+	if b.SSLMode != "" {
+		if sslMode, err = core.ParseBackendSSLMode(b.SSLMode); err != nil {
+			return err
+		}
+	}
+
+	key := RouteKey(b.DeploymentID, b.Pooled)
+	for _, address := range b.Addresses {
+		if _, _, err := net.SplitHostPort(address.Address); err != nil {
+			return fmt.Errorf("invalid address %q: %w", address.Address, err)
+		}
+		if address.Weight < 0 {
+			return fmt.Errorf("invalid weight %d for %s: must be >= 0", address.Weight, address.Address)
+		}
+		routes[key] = append(routes[key], Route{
+			Backend: core.Backend{
+				Address:           address.Address,
+				SendProxyProtocol: b.ProxyProtocol,
+				SSLMode:           sslMode,
+				Role:              role,
+			},
+			DatabaseType: databaseType,
+			Weight:       address.Weight,
+		})
+	}
+	return nil
+}
+
+// LoadFile reads path and swaps it in as the routing table. On error the
+// previous table is kept.
+func (r *Resolver) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return r.load(data)
+}
+
+func (r *Resolver) load(data []byte) error {
+	routes, err := ParseBackendsFile(data)
+	if err != nil {
+		return err
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = routes
+	r.fileHash = sha256.Sum256(data)
+	return nil
+}
+
+// WatchFile reloads path whenever its content changes, polling at interval
+// until stopCh is closed. Polling also picks up ConfigMap volume updates, which
+// replace a symlink rather than writing the file. Invalid edits are logged once
+// and the previous table stays in place until the file is fixed. Writes made by
+// the routes API are recognised and not reloaded.
+func (r *Resolver) WatchFile(path string, interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var rejected [sha256.Size]byte
+	readFailing := false
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if !readFailing {
+				logger.Error("Failed to read backends file, keeping previous routes", "path", path, "error", err)
+				metrics.BackendsFileReloads.With("error").Inc()
+			}
+			readFailing = true
+			continue
+		}
+		readFailing = false
+
+		hash := sha256.Sum256(data)
+		r.mu.RLock()
+		unchanged := hash == r.fileHash
+		r.mu.RUnlock()
+		if unchanged || hash == rejected {
+			continue
+		}
+
+		if err := r.load(data); err != nil {
+			rejected = hash
+			logger.Error("Rejected backends file change, keeping previous routes", "path", path, "error", err)
+			metrics.BackendsFileReloads.With("error").Inc()
+			continue
+		}
+		logger.Info("Reloaded backends file", "path", path)
+		metrics.BackendsFileReloads.With("success").Inc()
+	}
+}
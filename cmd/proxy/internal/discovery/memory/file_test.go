@@ -0,0 +1,164 @@
+package memory
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+)
+
+func TestParseBackendsFile(t *testing.T) {
+	enabled := true
+
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string][]Route
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			data: `
+backends:
+  - deployment_id: db1
+    database_type: postgresql
+    addresses: ["10.0.0.1:5432"]
+  - deployment_id: db1
+    pooled: true
+    proxy_protocol: true
+    addresses: ["10.0.0.1:6432"]
+  - deployment_id: db1
+    role: replica
+    sslmode: require
+    addresses:
+      - {address: "10.0.1.1:5432", weight: 3}
+      - "10.0.1.2:5432"
+`,
+			want: map[string][]Route{
+				"db1": {
+					{Backend: core.Backend{Address: "10.0.0.1:5432", Role: core.BackendRolePrimary}, DatabaseType: core.DatabaseTypePostgresql},
+					{Backend: core.Backend{Address: "10.0.1.1:5432", Role: core.BackendRoleReplica, SSLMode: core.BackendSSLModeRequire}, Weight: 3},
+					{Backend: core.Backend{Address: "10.0.1.2:5432", Role: core.BackendRoleReplica, SSLMode: core.BackendSSLModeRequire}},
+				},
+				"db1.pool": {
+					{Backend: core.Backend{Address: "10.0.0.1:6432", Role: core.BackendRolePrimary, SendProxyProtocol: &enabled}},
+				},
+			},
+		},
+		{
+			name:    "unknown field",
+			data:    "backends:\n  - deployment_id: db1\n    adresses: [\"10.0.0.1:5432\"]\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown address field",
+			data:    "backends:\n  - deployment_id: db1\n    addresses: [{address: \"10.0.0.1:5432\", wieght: 2}]\n",
+			wantErr: true,
+		},
+		{
+			name:    "bad address",
+			data:    "backends:\n  - deployment_id: db1\n    addresses: [\"10.0.0.1\"]\n",
+			wantErr: true,
+		},
+		{
+			name:    "negative weight",
+			data:    "backends:\n  - deployment_id: db1\n    addresses: [{address: \"10.0.0.1:5432\", weight: -1}]\n",
+			wantErr: true,
+		},
+		{
+			name:    "dotted deployment_id",
+			data:    "backends:\n  - deployment_id: db1.pool\n    addresses: [\"10.0.0.1:5432\"]\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing deployment_id",
+			data:    "backends:\n  - addresses: [\"10.0.0.1:5432\"]\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, err := ParseBackendsFile([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseBackendsFile() = %v, want error", routes)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseBackendsFile() failed: %v", err)
+			}
+			if !reflect.DeepEqual(routes, tt.want) {
+				t.Errorf("routes = %+v, want %+v", routes, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileAddressJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    FileAddress
+		wantErr bool
+	}{
+		{name: "string", data: `"10.0.0.1:5432"`, want: FileAddress{Address: "10.0.0.1:5432"}},
+		{name: "object", data: `{"address":"10.0.0.1:5432","weight":2}`, want: FileAddress{Address: "10.0.0.1:5432", Weight: 2}},
+		{name: "object without weight", data: `{"address":"10.0.0.1:5432"}`, want: FileAddress{Address: "10.0.0.1:5432"}},
+		{name: "object with unknown field", data: `{"address":"10.0.0.1:5432","wieght":2}`, wantErr: true},
+		{name: "number", data: `5432`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var address FileAddress
+			err := json.Unmarshal([]byte(tt.data), &address)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Unmarshal() = %+v, want error", address)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal() failed: %v", err)
+			}
+			if address != tt.want {
+				t.Errorf("address = %+v, want %+v", address, tt.want)
+			}
+
+			// Unweighted addresses are written back as strings, weighted ones as objects
+			data, err := json.Marshal(address)
+			if err != nil {
+				t.Fatalf("Marshal() failed: %v", err)
+			}
+			var roundTrip FileAddress
+			if err := json.Unmarshal(data, &roundTrip); err != nil || roundTrip != address {
+				t.Errorf("round trip of %s = %+v (%v), want %+v", data, roundTrip, err, address)
+			}
+			if isString := data[0] == '"'; isString != (address.Weight == 0) {
+				t.Errorf("Marshal() = %s, want a string only without weight", data)
+			}
+		})
+	}
+}
+
+func TestLoadInvalidKeepsRoutes(t *testing.T) {
+	r := &Resolver{}
+	if err := r.load([]byte("backends:\n  - deployment_id: db1\n    addresses: [\"10.0.0.1:5432\"]\n")); err != nil {
+		t.Fatalf("load valid file: %v", err)
+	}
+	want := r.Snapshot()
+	wantHash := r.fileHash
+
+	if err := r.load([]byte("backends:\n  - deployment_id: db2\n    addresses: [\"not an address\"]\n")); err == nil {
+		t.Fatalf("load of an invalid file succeeded")
+	}
+	if got := r.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("routes after invalid load = %+v, want %+v", got, want)
+	}
+	if r.fileHash != wantHash {
+		t.Errorf("file hash changed by an invalid load")
+	}
+}
@@ -2,62 +2,246 @@ package memory
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/balancer"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
 )
 
+// replicaSuffix marks replica backends in the mapping keys.
+const replicaSuffix = ".replica"
+
+// Route is one backend address of a deployment.
+type Route struct {
+	Backend      core.Backend
+	DatabaseType core.DatabaseType // Empty matches every database type
+	Weight       int               // Share of first picks among routes of the same role (0 = keep configured order)
+}
+
+// RouteKey returns the lookup key of a deployment: "deployment_id" or "deployment_id.pool".
+func RouteKey(deploymentID string, pooled bool) string {
+	if pooled {
+		return deploymentID + ".pool"
+	}
+	return deploymentID
+}
+
 type Resolver struct {
-	backends map[string]string
-	mu       sync.RWMutex
+	routes map[string][]Route
+	mu     sync.RWMutex
+
+	writeMu     sync.Mutex        // serialises table updates, including their persistence
+	persistPath string            // routes API changes are written here, see PersistTo
+	fileHash    [sha256.Size]byte // content of the last loaded or written backends file
 }
 
-// NewResolver creates a new memory resolver from a comma-separated string
-// Format: "deployment_id[.pool]=host:port,..."
-// Example: "db1=localhost:5432,db1.pool=localhost:6432"
+// NewResolver creates a new memory resolver from a comma-separated string, see ParseMapping.
 func NewResolver(mappingStr string) (*Resolver, error) {
-	backends := make(map[string]string)
+	routes, err := ParseMapping(mappingStr)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{routes: routes}, nil
+}
+
+// ParseMapping parses the STATIC_BACKENDS format into routes by RouteKey.
+// Format: "deployment_id[.pool][.replica]=host:port[|host:port...][?option=value&...],..."
+// Example: "db1=localhost:5432|localhost:5433,db1.pool=localhost:6432?proxy_protocol=true,db1.replica=localhost:5434"
+//
+// Keys ending in ".replica" are replicas, chosen according to target_session_attrs.
+// "|"-separated addresses are failover candidates, tried in order; options apply to all of them.
+//
+// Supported options:
+//   - proxy_protocol: true/false, overrides BACKEND_PROXY_PROTOCOL for this backend
+//   - sslmode: disable/prefer/require/verify-ca/verify-full, overrides BACKEND_SSLMODE
+func ParseMapping(mappingStr string) (map[string][]Route, error) {
+	routes := make(map[string][]Route)
 	if mappingStr == "" {
-		return &Resolver{backends: backends}, nil
+		return routes, nil
 	}
 
 	pairs := strings.Split(mappingStr, ",")
 	for _, pair := range pairs {
 		parts := strings.Split(strings.TrimSpace(pair), "=")
-		if len(parts) != 2 {
+		if len(parts) < 2 {
 			return nil, fmt.Errorf("invalid mapping format: %s", pair)
 		}
 		key := strings.TrimSpace(parts[0])
-		addr := strings.TrimSpace(parts[1])
-		backends[key] = addr
+		candidates, err := parseBackends(strings.TrimSpace(strings.Join(parts[1:], "=")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping %s: %w", pair, err)
+		}
+
+		role := core.BackendRolePrimary
+		if base, ok := strings.CutSuffix(key, replicaSuffix); ok {
+			key, role = base, core.BackendRoleReplica
+		}
+		for _, backend := range candidates {
+			backend.Role = role
+			routes[key] = append(routes[key], Route{Backend: backend})
+		}
 	}
 
-	return &Resolver{backends: backends}, nil
+	return routes, nil
+}
+
+// Replace atomically swaps the routing table. Sessions already relayed are not affected.
+func (r *Resolver) Replace(routes map[string][]Route) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = routes
 }
 
-func (r *Resolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) (string, error) {
+// parseBackends parses "host:port[|host:port...][?option=value&...]".
+func parseBackends(value string) ([]core.Backend, error) {
+	addrs, rawOptions, _ := strings.Cut(value, "?")
+	backend, err := parseOptions(rawOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []core.Backend
+	for _, addr := range strings.Split(addrs, "|") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			return nil, fmt.Errorf("empty backend address")
+		}
+		candidate := backend
+		candidate.Address = addr
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// parseOptions parses the per-backend query string options.
+func parseOptions(rawOptions string) (core.Backend, error) {
+	var backend core.Backend
+
+	options, err := url.ParseQuery(rawOptions)
+	if err != nil {
+		return core.Backend{}, fmt.Errorf("invalid options: %w", err)
+	}
+	for name := range options {
+		value := options.Get(name)
+		switch name {
+		case "proxy_protocol":
+			sendProxyProtocol, err := strconv.ParseBool(value)
+			if err != nil {
+				return core.Backend{}, fmt.Errorf("invalid proxy_protocol value: %s", value)
+			}
+			backend.SendProxyProtocol = &sendProxyProtocol
+		case "sslmode":
+			sslMode, err := core.ParseBackendSSLMode(value)
+			if err != nil {
+				return core.Backend{}, err
+			}
+			backend.SSLMode = sslMode
+		default:
+			return core.Backend{}, fmt.Errorf("unknown option: %s", name)
+		}
+	}
+
+	return backend, nil
+}
+
+func (r *Resolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	candidates, err := r.Lookup(ctx, metadata, databaseType)
+	if err != nil {
+		return nil, err
+	}
+	selected := candidates.Order()
+
+	logger.Debug("Static route resolved",
+		"deployment_id", metadata["deployment_id"],
+		"pooled", metadata["pooled"],
+		"backend", selected[0].Address)
+	return selected, nil
+}
+
+// Lookup returns the routes matching the deployment, database type and
+// target_session_attrs, to be ordered by weight on every resolution.
+func (r *Resolver) Lookup(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) (core.CandidateSet, error) {
 	deploymentID, ok := metadata["deployment_id"]
 	if !ok {
-		return "", fmt.Errorf("metadata missing 'deployment_id'")
+		return nil, fmt.Errorf("metadata missing 'deployment_id'")
 	}
 	pooled := metadata["pooled"]
 
 	// Construct lookup key: deployment_id or deployment_id.pool
-	key := deploymentID
-	if pooled == "true" {
-		key = deploymentID + ".pool"
+	key := RouteKey(deploymentID, pooled == "true")
+
+	attrs, err := core.ParseTargetSessionAttrs(metadata[core.MetadataTargetSessionAttrs])
+	if err != nil {
+		return nil, err
 	}
 
+	var routes []Route
 	r.mu.RLock()
-	addr, ok := r.backends[key]
+	for _, route := range r.routes[key] {
+		if route.DatabaseType == "" || route.DatabaseType == databaseType {
+			routes = append(routes, route)
+		}
+	}
 	r.mu.RUnlock()
 
-	if !ok {
-		return "", fmt.Errorf("backend not found for key: %s", key)
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("%w for key: %s", core.ErrBackendNotFound, key)
+	}
+
+	var tiers candidates
+	for _, role := range attrs.Roles() {
+		var tier []Route
+		for _, route := range routes {
+			if route.Backend.Role == role {
+				tier = append(tier, route)
+			}
+		}
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("%w: no backend for key %s matches target_session_attrs=%s", core.ErrBackendNotFound, key, attrs)
+	}
+	return tiers, nil
+}
+
+// candidates are the routes of a lookup by role, most preferred role first.
+type candidates [][]Route
+
+// Order returns the backends role by role, each role led by a weighted pick.
+func (c candidates) Order() []core.Backend {
+	var ordered []core.Backend
+	for _, tier := range c {
+		for _, route := range pickWeighted(tier) {
+			ordered = append(ordered, route.Backend)
+		}
+	}
+	return ordered
+}
+
+// pickWeighted moves a weighted random pick to the front, keeping the others in
+// configured order as failover candidates. Routes without weights keep their order.
+func pickWeighted(routes []Route) []Route {
+	weights := make([]int, len(routes))
+	for i, route := range routes {
+		weights[i] = route.Weight
+	}
+	i := balancer.PickWeighted(weights)
+	if i < 0 {
+		return routes
 	}
 
-	fmt.Printf("MemoryResolver: Routing %s (pooled=%s) to %s\n", deploymentID, pooled, addr)
-	return addr, nil
+	ordered := make([]Route, 0, len(routes))
+	ordered = append(ordered, routes[i])
+	ordered = append(ordered, routes[:i]...)
+	return append(ordered, routes[i+1:]...)
 }
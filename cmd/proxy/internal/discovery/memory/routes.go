@@ -0,0 +1,190 @@
+package memory
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ErrPersist is returned when a change could not be written to the persistence
+// file; the change is not applied then.
+var ErrPersist = errors.New("failed to persist routes")
+
+// PersistTo makes routes API changes write the whole table to path (in the
+// backends file format), so they survive restarts when path is also loaded at
+// startup. Changes are only applied once they are written.
+func (r *Resolver) PersistTo(path string) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	r.persistPath = path
+}
+
+// Snapshot returns the routing table in the backends file format, sorted by deployment.
+func (r *Resolver) Snapshot() BackendsFile {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return snapshotOf(r.routes)
+}
+
+// Deployment returns the direct and pooled routes of a deployment.
+func (r *Resolver) Deployment(deploymentID string) ([]FileBackend, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var backends []FileBackend
+	for _, pooled := range []bool{false, true} {
+		backends = append(backends, toFileBackends(deploymentID, pooled, r.routes[RouteKey(deploymentID, pooled)])...)
+	}
+	return backends, len(backends) > 0
+}
+
+// SetDeployment replaces all routes of a deployment. Entries without a
+// deployment_id get deploymentID; a different one is rejected. Sessions already
+// relayed are not affected.
+func (r *Resolver) SetDeployment(deploymentID string, backends []FileBackend) (created bool, err error) {
+	if len(backends) == 0 {
+		return false, fmt.Errorf("no backends for deployment %s", deploymentID)
+	}
+
+	routes := make(map[string][]Route)
+	for i, backend := range backends {
+		if backend.DeploymentID == "" {
+			backend.DeploymentID = deploymentID
+		}
+		if backend.DeploymentID != deploymentID {
+			return false, fmt.Errorf("backends[%d]: deployment_id %q does not match %q", i, backend.DeploymentID, deploymentID)
+		}
+		if err := backend.appendRoutes(routes); err != nil {
+			return false, fmt.Errorf("backends[%d]: %w", i, err)
+		}
+	}
+
+	err = r.update(func(table map[string][]Route) {
+		_, direct := table[RouteKey(deploymentID, false)]
+		_, pooled := table[RouteKey(deploymentID, true)]
+		created = !direct && !pooled
+
+		delete(table, RouteKey(deploymentID, false))
+		delete(table, RouteKey(deploymentID, true))
+		maps.Copy(table, routes)
+	})
+	return created, err
+}
+
+// DeleteDeployment removes all routes of a deployment and reports whether it existed.
+func (r *Resolver) DeleteDeployment(deploymentID string) (deleted bool, err error) {
+	err = r.update(func(table map[string][]Route) {
+		for _, pooled := range []bool{false, true} {
+			if _, ok := table[RouteKey(deploymentID, pooled)]; ok {
+				delete(table, RouteKey(deploymentID, pooled))
+				deleted = true
+			}
+		}
+	})
+	return deleted, err
+}
+
+// update applies change to a copy of the table, persists it if configured and
+// swaps it in. On error the table is left unchanged.
+func (r *Resolver) update(change func(table map[string][]Route)) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	r.mu.RLock()
+	table := maps.Clone(r.routes)
+	r.mu.RUnlock()
+	if table == nil {
+		table = make(map[string][]Route)
+	}
+	change(table)
+
+	var hash [sha256.Size]byte
+	if r.persistPath != "" {
+		data, err := yaml.Marshal(snapshotOf(table))
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrPersist, err)
+		}
+		if err := writeFileAtomic(r.persistPath, data); err != nil {
+			return fmt.Errorf("%w: %w", ErrPersist, err)
+		}
+		hash = sha256.Sum256(data)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = table
+	if r.persistPath != "" {
+		r.fileHash = hash
+	}
+	return nil
+}
+
+func snapshotOf(table map[string][]Route) BackendsFile {
+	file := BackendsFile{Backends: []FileBackend{}}
+	for _, key := range slices.Sorted(maps.Keys(table)) {
+		deploymentID, pooled := strings.CutSuffix(key, ".pool")
+		file.Backends = append(file.Backends, toFileBackends(deploymentID, pooled, table[key])...)
+	}
+	return file
+}
+
+// writeFileAtomic replaces path through a temporary file in the same
+// directory, so readers (and WatchFile) never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// toFileBackends converts the routes of one key back to file entries, merging
+// consecutive addresses with the same settings.
+func toFileBackends(deploymentID string, pooled bool, routes []Route) []FileBackend {
+	var backends []FileBackend
+	for _, route := range routes {
+		entry := FileBackend{
+			DeploymentID:  deploymentID,
+			Pooled:        pooled,
+			DatabaseType:  string(route.DatabaseType),
+			Role:          string(route.Backend.Role),
+			ProxyProtocol: route.Backend.SendProxyProtocol,
+			SSLMode:       string(route.Backend.SSLMode),
+		}
+		address := FileAddress{Address: route.Backend.Address, Weight: route.Weight}
+
+		if n := len(backends); n > 0 && sameSettings(backends[n-1], entry) {
+			backends[n-1].Addresses = append(backends[n-1].Addresses, address)
+			continue
+		}
+		entry.Addresses = []FileAddress{address}
+		backends = append(backends, entry)
+	}
+	return backends
+}
+
+func sameSettings(a, b FileBackend) bool {
+	sameProxyProtocol := (a.ProxyProtocol == nil && b.ProxyProtocol == nil) ||
+		(a.ProxyProtocol != nil && b.ProxyProtocol != nil && *a.ProxyProtocol == *b.ProxyProtocol)
+	return a.DatabaseType == b.DatabaseType && a.Role == b.Role && a.SSLMode == b.SSLMode && sameProxyProtocol
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdatePersistFailureKeepsRoutes(t *testing.T) {
+	r, err := NewResolver("db1=10.0.0.1:5432")
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	// The directory does not exist, so the temporary file cannot be created
+	r.PersistTo(filepath.Join(t.TempDir(), "missing", "routes.yaml"))
+	want := r.Snapshot()
+
+	if _, err := r.SetDeployment("db2", []FileBackend{{Addresses: []FileAddress{{Address: "10.0.0.2:5432"}}}}); !errors.Is(err, ErrPersist) {
+		t.Errorf("SetDeployment() error = %v, want %v", err, ErrPersist)
+	}
+	if _, err := r.DeleteDeployment("db1"); !errors.Is(err, ErrPersist) {
+		t.Errorf("DeleteDeployment() error = %v, want %v", err, ErrPersist)
+	}
+	if got := r.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("routes after failed updates = %+v, want %+v", got, want)
+	}
+}
+
+func TestUpdatePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	r, err := NewResolver("")
+	if err != nil {
+		t.Fatalf("new resolver: %v", err)
+	}
+	r.PersistTo(path)
+
+	created, err := r.SetDeployment("db1", []FileBackend{{Addresses: []FileAddress{{Address: "10.0.0.1:5432", Weight: 2}}}})
+	if err != nil || !created {
+		t.Fatalf("SetDeployment() = %v, %v, want created", created, err)
+	}
+
+	// The written file loads back to the same table
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read persisted routes: %v", err)
+	}
+	reloaded := &Resolver{}
+	if err := reloaded.load(data); err != nil {
+		t.Fatalf("load persisted routes: %v", err)
+	}
+	if got, want := reloaded.Snapshot(), r.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Errorf("persisted routes = %+v, want %+v", got, want)
+	}
+	if reloaded.fileHash != r.fileHash {
+		t.Errorf("file hash of the resolver does not match the written file, WatchFile would reload it")
+	}
+}
@@ -3,22 +3,32 @@ package factory
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"os"
+	"time"
 
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/balancer"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/config"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/discovery/memory"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
 	postgresql_proxy "github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/proxy/postgresql"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/routing"
 )
 
 // ProxyFactory creates protocol-specific proxy handlers
 type ProxyFactory struct {
-	cfg *config.Config
+	cfg         *config.Config
+	connections *balancer.ConnectionTracker
+
+	certificate *x509.Certificate // Served to clients, set once a TLS handler is created
 }
 
-// NewProxyFactory creates a new proxy factory
-func NewProxyFactory(cfg *config.Config) *ProxyFactory {
-	return &ProxyFactory{cfg: cfg}
+// NewProxyFactory creates a new proxy factory.
+// Sessions are reported to connections for least-connections balancing.
+func NewProxyFactory(cfg *config.Config, connections *balancer.ConnectionTracker) *ProxyFactory {
+	return &ProxyFactory{cfg: cfg, connections: connections}
 }
 
 // Create creates a connection handler based on database type
@@ -46,15 +56,123 @@ func (f *ProxyFactory) createPostgreSQLProxy(ctx context.Context, tlsProvider co
 		if err != nil {
 			return nil, fmt.Errorf("failed to load certificate for PostgreSQL proxy: %w", err)
 		}
+		if f.certificate, err = leafCertificate(cert); err != nil {
+			return nil, fmt.Errorf("failed to parse certificate for PostgreSQL proxy: %w", err)
+		}
 		tlsConfig = &tls.Config{
 			Certificates: []tls.Certificate{*cert},
+			// Required for PostgreSQL 17 direct SSL negotiation
+			NextProtos: []string{"postgresql"},
 		}
 	} else {
 		logger.Warn("TLS is disabled. Connections will not be encrypted!")
 	}
 
+	extractors, err := f.createRoutingExtractors(tlsConfig != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	backendRootCAs, err := f.loadBackendRootCAs()
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Backend TLS configured", "sslmode", f.cfg.BackendSSLMode, "root_cert", f.cfg.BackendSSLRootCert)
+
 	return &postgresql_proxy.PostgresProxy{
-		TLSConfig: tlsConfig,
-		Resolver:  resolver,
+		TLSConfig:         tlsConfig,
+		Resolver:          resolver,
+		SendProxyProtocol: f.cfg.BackendProxyProtocol,
+		BackendSSLMode:    core.BackendSSLMode(f.cfg.BackendSSLMode),
+		BackendRootCAs:    backendRootCAs,
+		RoutingExtractors: extractors,
+		CancelKeys:        memory.NewCancelKeyStore(),
+		Connections:       f.connections,
+		DialTimeout:       f.cfg.BackendDialTimeout,
+		ConnectRetries:    f.cfg.BackendConnectRetries,
+		RetryBackoff:      f.cfg.BackendRetryBackoff,
 	}, nil
 }
+
+// createRoutingExtractors builds the routing extractor chain from ROUTING_EXTRACTORS.
+// By default SNI routing (when a hostname template is configured) is tried before the username convention.
+func (f *ProxyFactory) createRoutingExtractors(tlsEnabled bool) ([]core.RoutingExtractor, error) {
+	var sniTemplate *core.HostnameTemplate
+	if f.cfg.SNIHostnameTemplate != "" {
+		var err error
+		sniTemplate, err = core.ParseHostnameTemplate(f.cfg.SNIHostnameTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SNI_HOSTNAME_TEMPLATE: %w", err)
+		}
+		if !tlsEnabled {
+			logger.Warn("SNI_HOSTNAME_TEMPLATE is set but TLS is disabled - SNI routing will never apply")
+		}
+	}
+
+	names := f.cfg.RoutingExtractors
+	if len(names) == 0 {
+		if sniTemplate != nil {
+			names = append(names, routing.ExtractorSNI)
+		}
+		names = append(names, routing.ExtractorUsernameSuffix)
+	}
+
+	extractors, err := routing.NewExtractors(names, sniTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ROUTING_EXTRACTORS: %w", err)
+	}
+	logger.Info("Routing extractors configured", "order", names, "sni_template", f.cfg.SNIHostnameTemplate)
+	return extractors, nil
+}
+
+// loadBackendRootCAs loads the CA bundle used to verify backend certificates.
+// It returns nil (system roots) when BACKEND_SSL_ROOT_CERT is not set.
+func (f *ProxyFactory) loadBackendRootCAs() (*x509.CertPool, error) {
+	if f.cfg.BackendSSLRootCert == "" {
+		return nil, nil
+	}
+
+	pemBytes, err := os.ReadFile(f.cfg.BackendSSLRootCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backend root certificate %s: %w", f.cfg.BackendSSLRootCert, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no certificates found in backend root certificate %s", f.cfg.BackendSSLRootCert)
+	}
+	return pool, nil
+}
+
+// ReadinessChecks returns the readiness checks of the handler created by Create:
+// with TLS enabled, that the certificate served to clients is loaded and valid.
+func (f *ProxyFactory) ReadinessChecks() []core.ReadinessCheck {
+	if !f.cfg.TLSEnabled {
+		return nil
+	}
+	return []core.ReadinessCheck{{Name: "tls", Check: f.certificateReady}}
+}
+
+func (f *ProxyFactory) certificateReady() error {
+	if f.certificate == nil {
+		return fmt.Errorf("no certificate loaded")
+	}
+	now := time.Now()
+	if now.After(f.certificate.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", f.certificate.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(f.certificate.NotBefore) {
+		return fmt.Errorf("certificate not valid before %s", f.certificate.NotBefore.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func leafCertificate(cert *tls.Certificate) (*x509.Certificate, error) {
+	if cert.Leaf != nil {
+		return cert.Leaf, nil
+	}
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("certificate chain is empty")
+	}
+	return x509.ParseCertificate(cert.Certificate[0])
+}
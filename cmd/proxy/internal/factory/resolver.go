@@ -5,12 +5,16 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/balancer"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/config"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/discovery/chain"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/discovery/dns"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/discovery/kubernetes"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/discovery/memory"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
 
+	"k8s.io/client-go/dynamic"
 	k8s "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -18,27 +22,80 @@ import (
 
 // ResolverFactory creates backend resolvers based on configuration
 type ResolverFactory struct {
-	cfg *config.Config
+	cfg         *config.Config
+	connections *balancer.ConnectionTracker
+
+	static *memory.Resolver      // Set once a static resolver is created, for the routes API
+	checks []core.ReadinessCheck // Readiness of the created resolvers
 }
 
-// NewResolverFactory creates a new resolver factory
-func NewResolverFactory(cfg *config.Config) *ResolverFactory {
-	return &ResolverFactory{cfg: cfg}
+// NewResolverFactory creates a new resolver factory.
+// connections is shared with the proxy for least-connections balancing.
+func NewResolverFactory(cfg *config.Config, connections *balancer.ConnectionTracker) *ResolverFactory {
+	return &ResolverFactory{cfg: cfg, connections: connections}
 }
 
 // Create creates a backend resolver based on configuration
 func (f *ResolverFactory) Create(ctx context.Context) (core.BackendResolver, *k8s.Clientset, error) {
-	switch f.cfg.DiscoveryMode {
+	return f.create(f.cfg.DiscoveryMode)
+}
+
+// StaticResolver returns the static resolver created by Create, if any,
+// including one that is part of a chain.
+func (f *ResolverFactory) StaticResolver() *memory.Resolver {
+	return f.static
+}
+
+// ReadinessChecks returns the readiness checks of the resolvers created by
+// Create: one for Kubernetes discovery, covering every watched cluster.
+func (f *ResolverFactory) ReadinessChecks() []core.ReadinessCheck {
+	return f.checks
+}
+
+func (f *ResolverFactory) create(mode config.DiscoveryMode) (core.BackendResolver, *k8s.Clientset, error) {
+	switch mode {
+	case config.DiscoveryChained:
+		return f.createChainResolver()
 	case config.DiscoveryStatic:
 		return f.createStaticResolver()
 	case config.DiscoveryKubernetes:
 		return f.createKubernetesResolver()
+	case config.DiscoveryDNS:
+		return f.createDNSResolver()
 	default:
-		return nil, nil, fmt.Errorf("unknown discovery mode: %s", f.cfg.DiscoveryMode)
+		return nil, nil, fmt.Errorf("unknown discovery mode: %s", mode)
+	}
+}
+
+// createChainResolver creates the resolvers of DISCOVERY_CHAIN and tries them in order.
+func (f *ResolverFactory) createChainResolver() (core.BackendResolver, *k8s.Clientset, error) {
+	logger.Info("Creating chained Backend Resolver", "chain", f.cfg.DiscoveryChain)
+
+	var links []chain.Link
+	var clientset *k8s.Clientset
+	for _, mode := range f.cfg.DiscoveryChain {
+		resolver, cs, err := f.create(config.DiscoveryMode(mode))
+		if err != nil {
+			return nil, nil, err
+		}
+		if cs != nil {
+			clientset = cs
+		}
+		links = append(links, chain.Link{Name: mode, Resolver: resolver})
+	}
+
+	resolver, err := chain.NewResolver(links...)
+	if err != nil {
+		return nil, nil, err
 	}
+	return resolver, clientset, nil
 }
 
 func (f *ResolverFactory) createStaticResolver() (core.BackendResolver, *k8s.Clientset, error) {
+	if f.cfg.StaticBackendsFile != "" {
+		return f.createFileResolver()
+	}
+
 	logger.Info("Creating Static Backend Resolver", "backends", f.cfg.StaticBackends)
 
 	resolver, err := memory.NewResolver(f.cfg.StaticBackends)
@@ -46,16 +103,112 @@ func (f *ResolverFactory) createStaticResolver() (core.BackendResolver, *k8s.Cli
 		return nil, nil, fmt.Errorf("failed to create static resolver: %w", err)
 	}
 
+	f.static = resolver
+	return resolver, nil, nil
+}
+
+func (f *ResolverFactory) createFileResolver() (core.BackendResolver, *k8s.Clientset, error) {
+	logger.Info("Creating Static Backend Resolver from file",
+		"path", f.cfg.StaticBackendsFile,
+		"reload_interval", f.cfg.StaticBackendsReloadInterval)
+
+	resolver, err := memory.NewResolver("")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := resolver.LoadFile(f.cfg.StaticBackendsFile); err != nil {
+		return nil, nil, fmt.Errorf("failed to load STATIC_BACKENDS_FILE: %w", err)
+	}
+	if f.cfg.RoutesAPIEnabled && f.cfg.RoutesAPIPersist {
+		resolver.PersistTo(f.cfg.StaticBackendsFile)
+	}
+
+	// Watch for the lifetime of the process, like the Kubernetes informers
+	go resolver.WatchFile(f.cfg.StaticBackendsFile, f.cfg.StaticBackendsReloadInterval, make(chan struct{}))
+
+	f.static = resolver
+	return resolver, nil, nil
+}
+
+func (f *ResolverFactory) createDNSResolver() (core.BackendResolver, *k8s.Clientset, error) {
+	logger.Info("Creating DNS SRV Backend Resolver",
+		"template", f.cfg.DNSSRVTemplate,
+		"server", f.cfg.DNSServer,
+		"cache_ttl", f.cfg.DNSCacheTTL)
+
+	template, err := core.ParseHostnameTemplate(f.cfg.DNSSRVTemplate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid DNS_SRV_TEMPLATE: %w", err)
+	}
+
+	resolver, err := dns.NewResolver(dns.Options{
+		Template: template,
+		Server:   f.cfg.DNSServer,
+		CacheTTL: f.cfg.DNSCacheTTL,
+		Timeout:  f.cfg.DNSTimeout,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create DNS resolver: %w", err)
+	}
+
 	return resolver, nil, nil
 }
 
 func (f *ResolverFactory) createKubernetesResolver() (core.BackendResolver, *k8s.Clientset, error) {
+	strategy, err := balancer.NewStrategy(f.cfg.LoadBalancingStrategy, f.connections)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.cfg.DiscoveryEndpointSlices {
+		logger.Info("Routing to pods via EndpointSlices", "strategy", f.cfg.LoadBalancingStrategy)
+	}
+
+	if len(f.cfg.KubeClusters) == 0 {
+		resolver, clientset, err := f.createClusterResolver(config.KubeCluster{KubeConfigPath: f.cfg.KubeConfigPath, Context: f.cfg.KubeContext}, strategy)
+		if err != nil {
+			return nil, nil, err
+		}
+		f.checks = append(f.checks, core.ReadinessCheck{Name: "kubernetes", Check: resolver.Ready})
+		return resolver, clientset, nil
+	}
+
+	// One informer set per cluster; the clientset of the first one serves the TLS secret
+	clusters := make([]kubernetes.Cluster, 0, len(f.cfg.KubeClusters))
+	var clientset *k8s.Clientset
+	for _, cluster := range f.cfg.KubeClusters {
+		resolver, cs, err := f.createClusterResolver(cluster, strategy)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cluster %s: %w", cluster.Name, err)
+		}
+		if clientset == nil {
+			clientset = cs
+		}
+		clusters = append(clusters, kubernetes.Cluster{Name: cluster.Name, Resolver: resolver})
+	}
+
+	resolver, err := kubernetes.NewMultiClusterResolver(kubernetes.Precedence(f.cfg.KubeClusterPrecedence), clusters...)
+	if err != nil {
+		return nil, nil, err
+	}
+	// One check for all clusters, so a single unreachable cluster only fails it
+	// when the precedence needs every cluster
+	f.checks = append(f.checks, core.ReadinessCheck{Name: "kubernetes", Check: resolver.Ready})
+	logger.Info("Multi-cluster Kubernetes resolver created", "clusters", len(clusters), "precedence", f.cfg.KubeClusterPrecedence)
+	return resolver, clientset, nil
+}
+
+// createClusterResolver creates the resolver of a single cluster.
+func (f *ResolverFactory) createClusterResolver(cluster config.KubeCluster, strategy balancer.Strategy) (*kubernetes.K8sResolver, *k8s.Clientset, error) {
+	kubeconfig := cluster.KubeConfigPath
+	if kubeconfig == "" {
+		kubeconfig = f.cfg.KubeConfigPath
+	}
+
 	logger.Info("Creating Kubernetes Backend Resolver",
+		"cluster", cluster.Name,
 		"runtime", f.cfg.Runtime,
-		"kubeconfig", f.cfg.KubeConfigPath,
-		"context", f.cfg.KubeContext)
-
-	kubeconfig := f.cfg.KubeConfigPath
+		"kubeconfig", kubeconfig,
+		"context", cluster.Context)
 
 	// For non-Kubernetes runtime, kubeconfig is required
 	if f.cfg.Runtime != config.RuntimeKubernetes && kubeconfig == "" {
@@ -65,9 +218,9 @@ func (f *ResolverFactory) createKubernetesResolver() (core.BackendResolver, *k8s
 	}
 
 	configOverrides := &clientcmd.ConfigOverrides{}
-	if f.cfg.KubeContext != "" {
-		configOverrides.CurrentContext = f.cfg.KubeContext
-		logger.Info("Using specific Kubernetes context", "context", f.cfg.KubeContext)
+	if cluster.Context != "" {
+		configOverrides.CurrentContext = cluster.Context
+		logger.Info("Using specific Kubernetes context", "context", cluster.Context)
 	}
 
 	var config *rest.Config
@@ -80,6 +233,10 @@ func (f *ResolverFactory) createKubernetesResolver() (core.BackendResolver, *k8s
 			configOverrides,
 		).ClientConfig()
 
+		if err != nil && cluster.Name != "" {
+			// Falling back could silently watch another cluster
+			return nil, nil, fmt.Errorf("failed to load kubeconfig: %w", err)
+		}
 		if err != nil {
 			logger.Warn("Failed to load kubeconfig, will try in-cluster config", "error", err)
 		}
@@ -99,7 +256,31 @@ func (f *ResolverFactory) createKubernetesResolver() (core.BackendResolver, *k8s
 		return nil, nil, fmt.Errorf("failed to create kubernetes client: %w", err)
 	}
 
-	resolver := kubernetes.NewK8sResolver(clientset)
-	logger.Info("Kubernetes resolver created successfully")
+	var dynamicClient dynamic.Interface
+	if f.cfg.DiscoveryRoutesEnabled {
+		if dynamicClient, err = dynamic.NewForConfig(config); err != nil {
+			return nil, nil, fmt.Errorf("failed to create kubernetes dynamic client: %w", err)
+		}
+		logger.Info("Watching XDatabaseRoute resources", "cluster", cluster.Name)
+	}
+
+	resolver, err := kubernetes.NewK8sResolver(clientset, kubernetes.Options{
+		Namespaces:        f.cfg.DiscoveryNamespaces,
+		NamespaceSelector: f.cfg.DiscoveryNamespaceSelector,
+		LabelPrefix:       f.cfg.DiscoveryLabelPrefix,
+		Cluster:           cluster.Name,
+		ClusterDomain:     cluster.Domain,
+		RouteToPods:       f.cfg.DiscoveryEndpointSlices,
+		Balancer:          strategy,
+		Routes:            f.cfg.DiscoveryRoutesEnabled,
+		Dynamic:           dynamicClient,
+		SyncTimeout:       f.cfg.DiscoverySyncTimeout,
+		StaleTimeout:      f.cfg.DiscoveryStaleTimeout,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create kubernetes resolver: %w", err)
+	}
+
+	logger.Info("Kubernetes resolver created successfully", "cluster", cluster.Name)
 	return resolver, clientset, nil
 }
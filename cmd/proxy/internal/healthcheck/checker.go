@@ -0,0 +1,255 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/metrics"
+)
+
+// forgetAfter drops backends that no resolution has returned for this long,
+// e.g. pods that were replaced.
+const forgetAfter = 10 * time.Minute
+
+// Options configure a Checker. Zero values fall back to the defaults in NewChecker.
+type Options struct {
+	Probe            Probe
+	Interval         time.Duration
+	Timeout          time.Duration
+	FailureThreshold int // Consecutive failures before a backend is ejected
+	SuccessThreshold int // Consecutive successes before an ejected backend is restored
+}
+
+// Checker wraps a resolver, probes every backend it has returned in the background
+// and removes ejected backends from resolution results.
+type Checker struct {
+	inner core.BackendResolver
+	opts  Options
+
+	mu       sync.Mutex
+	backends map[string]*backendState // by core.Backend.Key
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+type backendState struct {
+	backend     core.Backend
+	lastSeen    time.Time
+	deployments map[string]struct{} // deployment IDs resolved to this backend
+
+	healthy              bool
+	consecutiveFailures  int
+	consecutiveSuccesses int
+	lastError            string
+	lastCheck            time.Time
+	lastChange           time.Time
+}
+
+// Status is the health of one backend as reported on /backends.
+type Status struct {
+	Cluster              string    `json:"cluster,omitempty"`
+	Address              string    `json:"address"`
+	Deployments          []string  `json:"deployments"`
+	Healthy              bool      `json:"healthy"`
+	ConsecutiveFailures  int       `json:"consecutive_failures"`
+	ConsecutiveSuccesses int       `json:"consecutive_successes"`
+	LastError            string    `json:"last_error,omitempty"`
+	LastCheck            time.Time `json:"last_check"`
+	LastChange           time.Time `json:"last_change"`
+}
+
+func NewChecker(inner core.BackendResolver, opts Options) *Checker {
+	if opts.Probe == nil {
+		opts.Probe = PostgreSQLProbe{}
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 3
+	}
+	if opts.SuccessThreshold <= 0 {
+		opts.SuccessThreshold = 2
+	}
+	return &Checker{
+		inner:    inner,
+		opts:     opts,
+		backends: make(map[string]*backendState),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Resolve implements core.BackendResolver. Backends are learned from the inner
+// resolver and start out healthy; ejected ones are left out of the candidates.
+func (c *Checker) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	candidates, err := c.inner.Resolve(ctx, metadata, databaseType)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	healthy := make([]core.Backend, 0, len(candidates))
+	var lastError string
+
+	c.mu.Lock()
+	for _, backend := range candidates {
+		state, ok := c.backends[backend.Key()]
+		if !ok {
+			state = &backendState{healthy: true, lastChange: now, deployments: make(map[string]struct{})}
+			c.backends[backend.Key()] = state
+			metrics.BackendHealthy.With(backend.Cluster, backend.Address).Set(1)
+		}
+		state.backend = backend
+		state.lastSeen = now
+		state.deployments[metadata["deployment_id"]] = struct{}{}
+
+		if state.healthy {
+			healthy = append(healthy, backend)
+		} else {
+			lastError = state.lastError
+		}
+	}
+	c.mu.Unlock()
+
+	if len(healthy) == 0 {
+		return nil, fmt.Errorf("all %d backend candidates are unhealthy, last probe error: %s", len(candidates), lastError)
+	}
+	return healthy, nil
+}
+
+// Start runs the probe loop in the background until Stop is called.
+func (c *Checker) Start() {
+	go func() {
+		ticker := time.NewTicker(c.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stopCh:
+				return
+			case <-ticker.C:
+				c.checkAll()
+			}
+		}
+	}()
+}
+
+func (c *Checker) Stop() {
+	c.stopOnce.Do(func() { close(c.stopCh) })
+}
+
+// checkAll probes every known backend concurrently and waits for the results.
+func (c *Checker) checkAll() {
+	now := time.Now()
+
+	c.mu.Lock()
+	backends := make([]core.Backend, 0, len(c.backends))
+	for key, state := range c.backends {
+		if now.Sub(state.lastSeen) > forgetAfter {
+			delete(c.backends, key)
+			metrics.BackendHealthy.Delete(state.backend.Cluster, state.backend.Address)
+			continue
+		}
+		backends = append(backends, state.backend)
+	}
+	c.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, backend := range backends {
+		wg.Add(1)
+		go func(backend core.Backend) {
+			defer wg.Done()
+			c.record(backend.Key(), c.opts.Probe.Check(backend, c.opts.Timeout))
+		}(backend)
+	}
+	wg.Wait()
+}
+
+// record applies a probe result, ejecting or restoring the backend at the thresholds.
+func (c *Checker) record(key string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, ok := c.backends[key]
+	if !ok {
+		return
+	}
+	backend := state.backend
+	now := time.Now()
+	state.lastCheck = now
+
+	if err != nil {
+		state.lastError = err.Error()
+		state.consecutiveFailures++
+		state.consecutiveSuccesses = 0
+		if state.healthy && state.consecutiveFailures >= c.opts.FailureThreshold {
+			state.healthy = false
+			state.lastChange = now
+			metrics.BackendHealthy.With(backend.Cluster, backend.Address).Set(0)
+			logger.Warn("Backend ejected", "cluster", backend.Cluster, "backend_addr", backend.Address, "failures", state.consecutiveFailures, "error", err)
+		}
+		return
+	}
+
+	state.consecutiveSuccesses++
+	state.consecutiveFailures = 0
+	if !state.healthy && state.consecutiveSuccesses >= c.opts.SuccessThreshold {
+		state.healthy = true
+		state.lastError = ""
+		state.lastChange = now
+		metrics.BackendHealthy.With(backend.Cluster, backend.Address).Set(1)
+		logger.Info("Backend restored", "cluster", backend.Cluster, "backend_addr", backend.Address, "successes", state.consecutiveSuccesses)
+	}
+}
+
+// Statuses returns the health of all known backends, sorted by cluster and address.
+func (c *Checker) Statuses() []Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	statuses := make([]Status, 0, len(c.backends))
+	for _, state := range c.backends {
+		deployments := make([]string, 0, len(state.deployments))
+		for deploymentID := range state.deployments {
+			deployments = append(deployments, deploymentID)
+		}
+		sort.Strings(deployments)
+
+		statuses = append(statuses, Status{
+			Cluster:              state.backend.Cluster,
+			Address:              state.backend.Address,
+			Deployments:          deployments,
+			Healthy:              state.healthy,
+			ConsecutiveFailures:  state.consecutiveFailures,
+			ConsecutiveSuccesses: state.consecutiveSuccesses,
+			LastError:            state.lastError,
+			LastCheck:            state.lastCheck,
+			LastChange:           state.lastChange,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Cluster != statuses[j].Cluster {
+			return statuses[i].Cluster < statuses[j].Cluster
+		}
+		return statuses[i].Address < statuses[j].Address
+	})
+	return statuses
+}
+
+// Handler serves Statuses as JSON.
+func (c *Checker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Statuses())
+	})
+}
@@ -0,0 +1,148 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+)
+
+// fakeProbe fails the backends (by core.Backend.Key) it has an error for.
+type fakeProbe struct {
+	mu     sync.Mutex
+	errors map[string]error
+}
+
+func (p *fakeProbe) Check(backend core.Backend, timeout time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.errors[backend.Key()]
+}
+
+func (p *fakeProbe) fail(key string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.errors == nil {
+		p.errors = make(map[string]error)
+	}
+	if err == nil {
+		delete(p.errors, key)
+		return
+	}
+	p.errors[key] = err
+}
+
+// fixedResolver always returns the same backends, or err.
+type fixedResolver struct {
+	backends []core.Backend
+	err      error
+}
+
+func (r fixedResolver) Resolve(ctx context.Context, metadata core.RoutingMetadata, databaseType core.DatabaseType) ([]core.Backend, error) {
+	return r.backends, r.err
+}
+
+var testMetadata = core.RoutingMetadata{"deployment_id": "orders"}
+
+func resolveKeys(t *testing.T, c *Checker) []string {
+	t.Helper()
+	backends, err := c.Resolve(context.Background(), testMetadata, core.DatabaseTypePostgresql)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	keys := make([]string, len(backends))
+	for i, backend := range backends {
+		keys[i] = backend.Key()
+	}
+	return keys
+}
+
+func TestCheckerEjectsAndRestores(t *testing.T) {
+	probe := &fakeProbe{}
+	inner := fixedResolver{backends: []core.Backend{{Address: "10.0.0.1:5432"}, {Address: "10.0.0.2:5432"}}}
+	c := NewChecker(inner, Options{Probe: probe, FailureThreshold: 3, SuccessThreshold: 2})
+	all := []string{"10.0.0.1:5432", "10.0.0.2:5432"}
+
+	if got := resolveKeys(t, c); !slices.Equal(got, all) {
+		t.Fatalf("backends before probing = %v, want %v", got, all)
+	}
+
+	probe.fail("10.0.0.1:5432", errors.New("connection refused"))
+	for i := 1; i < 3; i++ {
+		c.checkAll()
+		if got := resolveKeys(t, c); !slices.Equal(got, all) {
+			t.Fatalf("backends after %d failures = %v, want %v", i, got, all)
+		}
+	}
+	c.checkAll()
+	if got, want := resolveKeys(t, c), []string{"10.0.0.2:5432"}; !slices.Equal(got, want) {
+		t.Fatalf("backends after 3 failures = %v, want %v", got, want)
+	}
+	status := c.Statuses()[0]
+	if status.Healthy || status.ConsecutiveFailures != 3 || status.LastError != "connection refused" ||
+		!slices.Equal(status.Deployments, []string{"orders"}) {
+		t.Errorf("status of the ejected backend = %+v", status)
+	}
+
+	probe.fail("10.0.0.1:5432", nil)
+	c.checkAll()
+	if got, want := resolveKeys(t, c), []string{"10.0.0.2:5432"}; !slices.Equal(got, want) {
+		t.Fatalf("backends after 1 success = %v, want %v", got, want)
+	}
+	c.checkAll()
+	if got := resolveKeys(t, c); !slices.Equal(got, all) {
+		t.Fatalf("backends after 2 successes = %v, want %v", got, all)
+	}
+	if status := c.Statuses()[0]; !status.Healthy || status.LastError != "" {
+		t.Errorf("status of the restored backend = %+v", status)
+	}
+}
+
+func TestCheckerAllUnhealthy(t *testing.T) {
+	probe := &fakeProbe{}
+	inner := fixedResolver{backends: []core.Backend{{Address: "10.0.0.1:5432"}, {Address: "10.0.0.2:5432"}}}
+	c := NewChecker(inner, Options{Probe: probe, FailureThreshold: 1})
+	resolveKeys(t, c)
+
+	probe.fail("10.0.0.1:5432", errors.New("connection refused"))
+	probe.fail("10.0.0.2:5432", errors.New("connection refused"))
+	c.checkAll()
+
+	_, err := c.Resolve(context.Background(), testMetadata, core.DatabaseTypePostgresql)
+	if err == nil || !strings.Contains(err.Error(), "all 2 backend candidates are unhealthy") || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("resolve error = %v, want all candidates unhealthy with the probe error", err)
+	}
+}
+
+func TestCheckerPassesResolverErrors(t *testing.T) {
+	c := NewChecker(fixedResolver{err: core.ErrBackendNotFound}, Options{Probe: &fakeProbe{}})
+	if _, err := c.Resolve(context.Background(), testMetadata, core.DatabaseTypePostgresql); !errors.Is(err, core.ErrBackendNotFound) {
+		t.Errorf("resolve error = %v, want %v", err, core.ErrBackendNotFound)
+	}
+}
+
+func TestCheckerKeysBackendsByCluster(t *testing.T) {
+	probe := &fakeProbe{}
+	inner := fixedResolver{backends: []core.Backend{
+		{Address: "10.0.0.1:5432", Cluster: "east"},
+		{Address: "10.0.0.1:5432", Cluster: "west"},
+	}}
+	c := NewChecker(inner, Options{Probe: probe, FailureThreshold: 1})
+	resolveKeys(t, c)
+
+	probe.fail("east/10.0.0.1:5432", errors.New("connection refused"))
+	c.checkAll()
+
+	if got, want := resolveKeys(t, c), []string{"west/10.0.0.1:5432"}; !slices.Equal(got, want) {
+		t.Errorf("backends = %v, want %v", got, want)
+	}
+	statuses := c.Statuses()
+	if len(statuses) != 2 || statuses[0].Cluster != "east" || statuses[0].Healthy || statuses[1].Cluster != "west" || !statuses[1].Healthy {
+		t.Errorf("statuses = %+v, want east ejected and west healthy", statuses)
+	}
+}
@@ -0,0 +1,93 @@
+package healthcheck
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/proxyproto"
+)
+
+// Probe types accepted by NewProbe (HEALTH_CHECK_TYPE).
+const (
+	ProbeTCP        = "tcp"
+	ProbePostgreSQL = "postgresql"
+)
+
+// sslRequestCode is the PostgreSQL SSLRequest code (1234.5679).
+const sslRequestCode = 80877103
+
+// Probe checks whether a backend is alive.
+type Probe interface {
+	Check(backend core.Backend, timeout time.Duration) error
+}
+
+// NewProbe returns the probe with the given name. sendProxyProtocol is the
+// proxy-wide BACKEND_PROXY_PROTOCOL default, which backends may override.
+func NewProbe(name string, sendProxyProtocol bool) (Probe, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case ProbeTCP:
+		return TCPProbe{}, nil
+	case ProbePostgreSQL, "":
+		return PostgreSQLProbe{SendProxyProtocol: sendProxyProtocol}, nil
+	default:
+		return nil, fmt.Errorf("unknown health check type: %s", name)
+	}
+}
+
+// TCPProbe succeeds if the backend accepts a TCP connection.
+type TCPProbe struct{}
+
+func (TCPProbe) Check(backend core.Backend, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", backend.Address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// PostgreSQLProbe sends an SSLRequest and expects 'S' or 'N', proving a PostgreSQL
+// server (or pooler) is answering without starting a session or authenticating.
+type PostgreSQLProbe struct {
+	SendProxyProtocol bool
+}
+
+func (p PostgreSQLProbe) Check(backend core.Backend, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", backend.Address, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// Backends that require a PROXY header get a LOCAL one, as for any health check
+	sendProxyProtocol := p.SendProxyProtocol
+	if backend.SendProxyProtocol != nil {
+		sendProxyProtocol = *backend.SendProxyProtocol
+	}
+	if sendProxyProtocol {
+		if err := proxyproto.WriteV2(conn, nil, nil); err != nil {
+			return fmt.Errorf("failed to send PROXY protocol header: %w", err)
+		}
+	}
+
+	sslRequest := make([]byte, 8)
+	binary.BigEndian.PutUint32(sslRequest[0:4], 8)
+	binary.BigEndian.PutUint32(sslRequest[4:8], sslRequestCode)
+	if _, err := conn.Write(sslRequest); err != nil {
+		return fmt.Errorf("failed to send SSLRequest: %w", err)
+	}
+
+	response := make([]byte, 1)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return fmt.Errorf("failed to read SSLRequest response: %w", err)
+	}
+	if response[0] != 'S' && response[0] != 'N' {
+		return fmt.Errorf("unexpected SSLRequest response: %q", response[0])
+	}
+	return nil
+}
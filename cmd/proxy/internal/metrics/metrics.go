@@ -0,0 +1,333 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxSeriesPerMetric caps label cardinality. Deployment IDs come from clients,
+// so a misconfigured or hostile client could otherwise create unbounded series.
+const maxSeriesPerMetric = 10000
+
+// overflowLabelValue replaces every label value once a metric hits maxSeriesPerMetric.
+const overflowLabelValue = "_overflow_"
+
+// Collector is anything that can write itself in the Prometheus text format.
+type Collector interface {
+	writeTo(w *bufio.Writer)
+}
+
+// Registry holds collectors and renders them for scraping.
+type Registry struct {
+	mu         sync.RWMutex
+	collectors []Collector
+}
+
+// DefaultRegistry is the registry served on /metrics.
+var DefaultRegistry = &Registry{}
+
+// Register adds collectors to the registry.
+func (r *Registry) Register(collectors ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format (0.0.4).
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	bw := bufio.NewWriter(w)
+	for _, c := range r.collectors {
+		c.writeTo(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler serves the registry over HTTP.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// atomicFloat is a float64 updated with compare-and-swap.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Add(v float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+// vec maps label values to series. It is shared by all metric types.
+type vec[T any] struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	newSeries  func() *T
+
+	mu     sync.RWMutex
+	series map[string]*T
+	values map[string][]string
+}
+
+func newVec[T any](name, help, metricType string, labelNames []string, newSeries func() *T) *vec[T] {
+	return &vec[T]{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		newSeries:  newSeries,
+		series:     make(map[string]*T),
+		values:     make(map[string][]string),
+	}
+}
+
+func (v *vec[T]) with(labelValues ...string) *T {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[key]; ok {
+		return s
+	}
+	if len(v.series) >= maxSeriesPerMetric {
+		labelValues = make([]string, len(v.labelNames))
+		for i := range labelValues {
+			labelValues[i] = overflowLabelValue
+		}
+		key = strings.Join(labelValues, "\xff")
+		if s, ok := v.series[key]; ok {
+			return s
+		}
+	}
+	s = v.newSeries()
+	v.series[key] = s
+	v.values[key] = append([]string(nil), labelValues...)
+	return s
+}
+
+func (v *vec[T]) delete(labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.series, key)
+	delete(v.values, key)
+}
+
+// each visits series in a stable order.
+func (v *vec[T]) each(fn func(labelValues []string, s *T)) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fn(v.values[key], v.series[key])
+	}
+}
+
+func (v *vec[T]) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.metricType)
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	value atomicFloat
+}
+
+func (c *Counter) Inc()          { c.value.Add(1) }
+func (c *Counter) Add(v float64) { c.value.Add(v) }
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec *vec[Counter]
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{vec: newVec(name, help, "counter", labelNames, func() *Counter { return &Counter{} })}
+}
+
+func (c *CounterVec) With(labelValues ...string) *Counter {
+	return c.vec.with(labelValues...)
+}
+
+func (c *CounterVec) writeTo(w *bufio.Writer) {
+	c.vec.writeHeader(w)
+	c.vec.each(func(labelValues []string, s *Counter) {
+		writeSample(w, c.vec.name, c.vec.labelNames, labelValues, "", "", s.value.Load())
+	})
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	value atomicFloat
+}
+
+func (g *Gauge) Inc()          { g.value.Add(1) }
+func (g *Gauge) Dec()          { g.value.Add(-1) }
+func (g *Gauge) Add(v float64) { g.value.Add(v) }
+
+func (g *Gauge) Set(v float64) {
+	g.value.bits.Store(math.Float64bits(v))
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	vec *vec[Gauge]
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{vec: newVec(name, help, "gauge", labelNames, func() *Gauge { return &Gauge{} })}
+}
+
+func (g *GaugeVec) With(labelValues ...string) *Gauge {
+	return g.vec.with(labelValues...)
+}
+
+// Delete removes a series, e.g. for a backend that no longer exists.
+func (g *GaugeVec) Delete(labelValues ...string) {
+	g.vec.delete(labelValues...)
+}
+
+func (g *GaugeVec) writeTo(w *bufio.Writer) {
+	g.vec.writeHeader(w)
+	g.vec.each(func(labelValues []string, s *Gauge) {
+		writeSample(w, g.vec.name, g.vec.labelNames, labelValues, "", "", s.value.Load())
+	})
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	upperBounds []float64
+	counts      []atomic.Uint64 // per bucket (non-cumulative), last one is +Inf
+	sum         atomicFloat
+	count       atomic.Uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	h.counts[i].Add(1)
+	h.sum.Add(v)
+	h.count.Add(1)
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec     *vec[Histogram]
+	buckets []float64
+}
+
+// DefaultBuckets suit latencies measured in seconds.
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{
+		buckets: buckets,
+		vec: newVec(name, help, "histogram", labelNames, func() *Histogram {
+			return &Histogram{upperBounds: buckets, counts: make([]atomic.Uint64, len(buckets)+1)}
+		}),
+	}
+}
+
+func (h *HistogramVec) With(labelValues ...string) *Histogram {
+	return h.vec.with(labelValues...)
+}
+
+func (h *HistogramVec) writeTo(w *bufio.Writer) {
+	h.vec.writeHeader(w)
+	h.vec.each(func(labelValues []string, s *Histogram) {
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += s.counts[i].Load()
+			writeSample(w, h.vec.name+"_bucket", h.vec.labelNames, labelValues, "le", formatFloat(upperBound), float64(cumulative))
+		}
+		cumulative += s.counts[len(h.buckets)].Load()
+		writeSample(w, h.vec.name+"_bucket", h.vec.labelNames, labelValues, "le", "+Inf", float64(cumulative))
+		writeSample(w, h.vec.name+"_sum", h.vec.labelNames, labelValues, "", "", s.sum.Load())
+		writeSample(w, h.vec.name+"_count", h.vec.labelNames, labelValues, "", "", float64(s.count.Load()))
+	})
+}
+
+func writeSample(w *bufio.Writer, name string, labelNames, labelValues []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labelName, escapeLabelValue(labelValues[i]))
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabelValue(v string) string { return labelValueEscaper.Replace(v) }
+func escapeHelp(v string) string       { return helpEscaper.Replace(v) }
@@ -0,0 +1,115 @@
+package metrics
+
+import "io"
+
+// Proxy metrics. Session-level metrics are labelled by deployment_id and pooled.
+var (
+	ConnectionsAccepted = NewCounterVec(
+		"xdatabase_proxy_connections_accepted_total",
+		"Client connections accepted by the proxy.")
+
+	ActiveConnections = NewGaugeVec(
+		"xdatabase_proxy_active_connections",
+		"Client connections currently open (including those still in handshake).")
+
+	HandshakeFailures = NewCounterVec(
+		"xdatabase_proxy_handshake_failures_total",
+		"Client connections that failed during protocol or TLS negotiation.")
+
+	TLSHandshakes = NewCounterVec(
+		"xdatabase_proxy_tls_handshakes_total",
+		"Successful client TLS handshakes by protocol version and cipher suite.",
+		"version", "cipher_suite", "negotiation")
+
+	Resolutions = NewCounterVec(
+		"xdatabase_proxy_resolutions_total",
+		"Backend resolutions by outcome (success, not_found, error).",
+		"deployment_id", "pooled", "outcome")
+
+	ResolutionDuration = NewHistogramVec(
+		"xdatabase_proxy_resolution_duration_seconds",
+		"Time spent resolving a backend.",
+		DefaultBuckets,
+		"deployment_id", "pooled")
+
+	BackendDialDuration = NewHistogramVec(
+		"xdatabase_proxy_backend_dial_duration_seconds",
+		"Time to connect to a backend, including PROXY header and TLS negotiation.",
+		DefaultBuckets,
+		"deployment_id", "pooled", "outcome")
+
+	BytesTransferred = NewCounterVec(
+		"xdatabase_proxy_bytes_total",
+		"Bytes relayed between clients and backends.",
+		"deployment_id", "pooled", "direction")
+
+	ActiveSessions = NewGaugeVec(
+		"xdatabase_proxy_active_sessions",
+		"Sessions currently relayed to a backend.",
+		"deployment_id", "pooled")
+
+	SessionDuration = NewHistogramVec(
+		"xdatabase_proxy_session_duration_seconds",
+		"Duration of relayed sessions.",
+		[]float64{0.1, 0.5, 1, 5, 15, 60, 300, 900, 3600, 14400, 86400},
+		"deployment_id", "pooled")
+
+	BackendHealthy = NewGaugeVec(
+		"xdatabase_proxy_backend_healthy",
+		"Whether the health checker considers a backend healthy (1) or ejected (0).",
+		"cluster", "backend_addr")
+
+	BackendsFileReloads = NewCounterVec(
+		"xdatabase_proxy_backends_file_reloads_total",
+		"Reloads of STATIC_BACKENDS_FILE by outcome (success, error).",
+		"outcome")
+
+	ResolverCacheLookups = NewCounterVec(
+		"xdatabase_proxy_resolver_cache_lookups_total",
+		"Resolver cache lookups by result (hit, negative_hit, miss, stale).",
+		"result")
+)
+
+// Traffic directions for BytesTransferred.
+const (
+	DirectionClientToBackend = "client_to_backend"
+	DirectionBackendToClient = "backend_to_client"
+)
+
+func init() {
+	DefaultRegistry.Register(
+		ConnectionsAccepted,
+		ActiveConnections,
+		HandshakeFailures,
+		TLSHandshakes,
+		Resolutions,
+		ResolutionDuration,
+		BackendDialDuration,
+		BytesTransferred,
+		ActiveSessions,
+		SessionDuration,
+		BackendHealthy,
+		BackendsFileReloads,
+		ResolverCacheLookups,
+	)
+
+	// Unlabelled series are exported as 0 from the start
+	ConnectionsAccepted.With()
+	ActiveConnections.With()
+	HandshakeFailures.With()
+}
+
+// CountingWriter adds every successful write to a byte counter, so long-lived
+// sessions report traffic while they are running rather than when they end.
+type CountingWriter struct {
+	W       io.Writer
+	Counter *Counter
+}
+
+func (w *CountingWriter) Write(b []byte) (int, error) {
+	n, err := w.W.Write(b)
+	if n > 0 {
+		w.Counter.Add(float64(n))
+	}
+	return n, err
+}
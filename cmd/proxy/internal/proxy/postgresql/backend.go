@@ -0,0 +1,198 @@
+package postgresql_proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/proxyproto"
+)
+
+// connectCandidates tries the candidates in order, retrying the whole list with
+// exponential backoff, and returns the first backend that accepts the connection.
+func (p *PostgresProxy) connectCandidates(candidates []core.Backend, clientConn net.Conn) (core.Backend, net.Conn, error) {
+	var lastErr error
+	backoff := p.RetryBackoff
+	for attempt := 0; attempt <= p.ConnectRetries; attempt++ {
+		if attempt > 0 && backoff > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		for _, backend := range candidates {
+			conn, err := p.connectBackend(backend, clientConn)
+			if err == nil {
+				return backend, conn, nil
+			}
+			lastErr = err
+			logger.Warn("Backend candidate failed",
+				"backend_addr", backend.Address,
+				"attempt", attempt+1,
+				"error", err,
+				"remote_addr", clientConn.RemoteAddr())
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no backend candidates")
+	}
+	return core.Backend{}, nil, fmt.Errorf("all %d backend candidates failed after %d attempts, last error: %w", len(candidates), p.ConnectRetries+1, lastErr)
+}
+
+// connectBackend dials the backend and prepares it to receive the startup message:
+// it sends the PROXY header (if enabled) and negotiates TLS according to the sslmode.
+// clientConn is only used for the addresses announced in the PROXY header.
+// DialTimeout bounds the whole sequence.
+func (p *PostgresProxy) connectBackend(backend core.Backend, clientConn net.Conn) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", backend.Address, p.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if p.DialTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(p.DialTimeout))
+	}
+
+	if p.sendProxyProtocol(backend) {
+		if err := proxyproto.WriteV2(conn, clientConn.RemoteAddr(), clientConn.LocalAddr()); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to send PROXY protocol header: %w", err)
+		}
+	}
+
+	tlsConn, err := p.negotiateBackendTLS(conn, backend)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if p.DialTimeout > 0 {
+		tlsConn.SetDeadline(time.Time{})
+	}
+	return tlsConn, nil
+}
+
+// sendProxyProtocol reports whether a PROXY header must be sent to the backend.
+func (p *PostgresProxy) sendProxyProtocol(backend core.Backend) bool {
+	if backend.SendProxyProtocol != nil {
+		return *backend.SendProxyProtocol
+	}
+	return p.SendProxyProtocol
+}
+
+// backendSSLMode returns the effective sslmode for a backend.
+func (p *PostgresProxy) backendSSLMode(backend core.Backend) core.BackendSSLMode {
+	mode := p.BackendSSLMode
+	if backend.SSLMode != "" {
+		mode = backend.SSLMode
+	}
+	if mode == "" {
+		return core.BackendSSLModeDisable
+	}
+	// Like libpq, "require" verifies the chain when a root CA bundle is configured
+	if mode == core.BackendSSLModeRequire && p.BackendRootCAs != nil {
+		return core.BackendSSLModeVerifyCA
+	}
+	return mode
+}
+
+// negotiateBackendTLS performs the SSLRequest exchange toward the backend.
+func (p *PostgresProxy) negotiateBackendTLS(conn net.Conn, backend core.Backend) (net.Conn, error) {
+	mode := p.backendSSLMode(backend)
+	if mode == core.BackendSSLModeDisable {
+		return conn, nil
+	}
+
+	sslRequest := make([]byte, 8)
+	binary.BigEndian.PutUint32(sslRequest[0:4], 8)
+	binary.BigEndian.PutUint32(sslRequest[4:8], sslRequestCode)
+	if _, err := conn.Write(sslRequest); err != nil {
+		return nil, fmt.Errorf("failed to send SSLRequest to backend: %w", err)
+	}
+
+	response := make([]byte, 1)
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, fmt.Errorf("failed to read SSLRequest response from backend: %w", err)
+	}
+
+	switch response[0] {
+	case 'S':
+		tlsConn := tls.Client(conn, p.backendTLSConfig(mode, backend))
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, fmt.Errorf("backend TLS handshake failed (sslmode=%s): %w", mode, err)
+		}
+		return tlsConn, nil
+	case 'N':
+		if mode == core.BackendSSLModePrefer {
+			return conn, nil
+		}
+		return nil, fmt.Errorf("backend does not support SSL (sslmode=%s)", mode)
+	default:
+		return nil, fmt.Errorf("unexpected SSLRequest response from backend: %q", response[0])
+	}
+}
+
+func (p *PostgresProxy) backendTLSConfig(mode core.BackendSSLMode, backend core.Backend) *tls.Config {
+	host := backend.ServerName
+	if host == "" {
+		var err error
+		if host, _, err = net.SplitHostPort(backend.Address); err != nil {
+			host = backend.Address
+		}
+	}
+
+	switch mode {
+	case core.BackendSSLModeVerifyFull:
+		return &tls.Config{
+			ServerName: host,
+			RootCAs:    p.BackendRootCAs,
+		}
+	case core.BackendSSLModeVerifyCA:
+		// Verify the chain but not the hostname, like libpq's verify-ca
+		roots := p.BackendRootCAs
+		return &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyConnection: func(state tls.ConnectionState) error {
+				return verifyChain(state, roots)
+			},
+		}
+	default:
+		// prefer / require: encryption without verification
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+}
+
+func verifyChain(state tls.ConnectionState, roots *x509.CertPool) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("backend presented no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// allowedCandidates drops the candidates whose AllowedSources exclude the client.
+// A client address that isn't IP-based only passes unrestricted candidates.
+func allowedCandidates(candidates []core.Backend, clientAddr net.Addr) []core.Backend {
+	var addr netip.Addr
+	if tcpAddr, ok := clientAddr.(*net.TCPAddr); ok {
+		addr = tcpAddr.AddrPort().Addr()
+	}
+
+	allowed := candidates[:0:0]
+	for _, backend := range candidates {
+		if len(backend.AllowedSources) == 0 || (addr.IsValid() && backend.Allows(addr)) {
+			allowed = append(allowed, backend)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,32 @@
+package postgresql_proxy
+
+import (
+	"testing"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+)
+
+func TestBackendTLSConfigServerName(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend core.Backend
+		want    string
+	}{
+		{name: "host of the address", backend: core.Backend{Address: "db.example.com:5432"}, want: "db.example.com"},
+		{name: "pod reached by IP", backend: core.Backend{Address: "10.0.0.7:5432", ServerName: "db.tenants.svc.cluster.local"}, want: "db.tenants.svc.cluster.local"},
+		{name: "address without port", backend: core.Backend{Address: "db.example.com"}, want: "db.example.com"},
+	}
+
+	proxy := &PostgresProxy{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := proxy.backendTLSConfig(core.BackendSSLModeVerifyFull, tt.backend)
+			if config.ServerName != tt.want {
+				t.Errorf("ServerName = %q, want %q", config.ServerName, tt.want)
+			}
+			if config.InsecureSkipVerify {
+				t.Errorf("verify-full skips verification")
+			}
+		})
+	}
+}
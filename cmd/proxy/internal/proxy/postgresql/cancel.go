@@ -0,0 +1,125 @@
+package postgresql_proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+)
+
+const (
+	cancelRequestCode = 80877102
+
+	// Backend message types relevant to session setup
+	msgBackendKeyData = 'K'
+	msgReadyForQuery  = 'Z'
+
+	// Upper bound for a single startup-phase message (auth, parameter status, notices)
+	maxStartupResponseLength = 1 << 20
+)
+
+// cancelRequest is returned by handshake when the client opened the connection
+// to cancel a running query instead of starting a session.
+type cancelRequest struct {
+	key core.CancelKey
+	raw []byte // original packet, forwarded verbatim
+}
+
+func (c *cancelRequest) Error() string {
+	return fmt.Sprintf("cancel request for backend pid %d", c.key.ProcessID)
+}
+
+// parseCancelRequest decodes the CancelRequest payload (after the length field).
+func parseCancelRequest(header, payload []byte) (*cancelRequest, error) {
+	// request code + process ID + secret key (at least 4 bytes)
+	if len(payload) < 12 {
+		return nil, fmt.Errorf("cancel request too short")
+	}
+	raw := make([]byte, 0, len(header)+len(payload))
+	raw = append(raw, header...)
+	raw = append(raw, payload...)
+	return &cancelRequest{
+		key: core.CancelKey{
+			ProcessID: binary.BigEndian.Uint32(payload[4:8]),
+			SecretKey: string(payload[8:]),
+		},
+		raw: raw,
+	}, nil
+}
+
+// forwardCancelRequest sends the client's CancelRequest to the backend owning the key.
+// PostgreSQL never answers a CancelRequest, so the client only sees the connection close.
+func (p *PostgresProxy) forwardCancelRequest(clientConn net.Conn, req *cancelRequest) {
+	if p.CancelKeys == nil {
+		logger.Warn("Cancel request ignored - cancel routing disabled", "remote_addr", clientConn.RemoteAddr())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	backend, ok, err := p.CancelKeys.Lookup(ctx, req.key)
+	if err != nil {
+		logger.Error("Cancel key lookup failed", "pid", req.key.ProcessID, "error", err, "remote_addr", clientConn.RemoteAddr())
+		return
+	}
+	if !ok {
+		logger.Warn("Cancel request for unknown backend session", "pid", req.key.ProcessID, "remote_addr", clientConn.RemoteAddr())
+		return
+	}
+
+	backendConn, err := p.connectBackend(backend, clientConn)
+	if err != nil {
+		logger.Error("Failed to connect to backend for cancel request", "backend_addr", backend.Address, "error", err, "remote_addr", clientConn.RemoteAddr())
+		return
+	}
+	defer backendConn.Close()
+
+	if _, err := backendConn.Write(req.raw); err != nil {
+		logger.Error("Failed to forward cancel request", "backend_addr", backend.Address, "error", err, "remote_addr", clientConn.RemoteAddr())
+		return
+	}
+	logger.Info("Cancel request forwarded", "backend_addr", backend.Address, "pid", req.key.ProcessID, "remote_addr", clientConn.RemoteAddr())
+}
+
+// relayStartupResponse copies backend messages to the client until the session is
+// ready for queries, returning the BackendKeyData announced along the way (if any).
+func relayStartupResponse(clientConn io.Writer, backendConn net.Conn) (*core.CancelKey, error) {
+	var key *core.CancelKey
+	header := make([]byte, 5)
+
+	for {
+		if _, err := io.ReadFull(backendConn, header); err != nil {
+			return key, err
+		}
+		length := binary.BigEndian.Uint32(header[1:5])
+		if length < 4 || length > maxStartupResponseLength {
+			return key, fmt.Errorf("invalid backend message length: %d", length)
+		}
+
+		body := make([]byte, length-4)
+		if _, err := io.ReadFull(backendConn, body); err != nil {
+			return key, err
+		}
+
+		if header[0] == msgBackendKeyData && len(body) >= 4 {
+			key = &core.CancelKey{
+				ProcessID: binary.BigEndian.Uint32(body[0:4]),
+				SecretKey: string(body[4:]),
+			}
+		}
+
+		if _, err := clientConn.Write(append(header, body...)); err != nil {
+			return key, err
+		}
+
+		if header[0] == msgReadyForQuery {
+			return key, nil
+		}
+	}
+}
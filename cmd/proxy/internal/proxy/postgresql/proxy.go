@@ -4,20 +4,37 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"strings"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/metrics"
 )
 
 const (
-	sslRequestCode = 80877103
+	sslRequestCode    = 80877103
+	gssEncRequestCode = 80877104
+
+	// Request codes live in the reserved 1234.x protocol version range
+	negotiationMajorVersion = 1234
+	protocolMajorVersion    = 3
+
+	// Same limit PostgreSQL applies to startup packets
+	maxStartupPacketLength = 10000
+
+	// First byte of a TLS handshake record (ClientHello), used to detect direct SSL
+	tlsRecordTypeHandshake = 0x16
+
+	// ALPN protocol name registered for PostgreSQL
+	alpnProtocol = "postgresql"
 )
 
 // ErrorResponse represents a PostgreSQL error response
@@ -30,6 +47,33 @@ type ErrorResponse struct {
 type PostgresProxy struct {
 	TLSConfig *tls.Config
 	Resolver  core.BackendResolver
+
+	// SendProxyProtocol emits a PROXY v2 header on backend connections so the
+	// backend sees the real client address. Backends may override it.
+	SendProxyProtocol bool
+
+	// BackendSSLMode is the default proxy-to-backend TLS mode. Backends may override it.
+	BackendSSLMode core.BackendSSLMode
+
+	// BackendRootCAs verifies backend certificates (nil = system roots).
+	BackendRootCAs *x509.CertPool
+
+	// RoutingExtractors derive deployment_id and pool status from the handshake, tried in order.
+	RoutingExtractors []core.RoutingExtractor
+
+	// CancelKeys routes CancelRequests to the backend that owns the session (nil disables cancel routing).
+	CancelKeys core.CancelKeyStore
+
+	// Connections is told about every relayed session (optional), e.g. for least-connections balancing.
+	Connections core.ConnectionTracker
+
+	// DialTimeout bounds connecting to one backend candidate, including PROXY header and TLS (0 = no limit).
+	DialTimeout time.Duration
+
+	// ConnectRetries is how many more times the candidate list is tried after every candidate failed,
+	// waiting RetryBackoff before the first retry and doubling it each time.
+	ConnectRetries int
+	RetryBackoff   time.Duration
 }
 
 func (p *PostgresProxy) sendErrorResponse(conn net.Conn, errResp *ErrorResponse) error {
@@ -64,20 +108,39 @@ func (p *PostgresProxy) sendErrorResponse(conn net.Conn, errResp *ErrorResponse)
 func (p *PostgresProxy) HandleConnection(clientConn net.Conn) {
 	defer clientConn.Close()
 
+	metrics.ConnectionsAccepted.With().Inc()
+	metrics.ActiveConnections.With().Inc()
+	defer metrics.ActiveConnections.With().Dec()
+
 	// 1. Handshake & Protocol Parsing
-	metadata, clientConn, rawStartupMsg, err := p.handshake(clientConn)
+	metadata, sessionConn, rawStartupMsg, err := p.handshake(clientConn)
+	var cancelReq *cancelRequest
+	if errors.As(err, &cancelReq) {
+		p.forwardCancelRequest(clientConn, cancelReq)
+		return
+	}
 	if err != nil {
+		metrics.HandshakeFailures.With().Inc()
 		logger.Error("Handshake failed", "error", err, "remote_addr", clientConn.RemoteAddr())
 		// Try to send error response if possible, but handshake error might mean we can't speak protocol
 		return
 	}
+	clientConn = sessionConn
 
 	// 2. Resolve Backend
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	backendAddr, err := p.Resolver.Resolve(ctx, metadata, core.DatabaseTypePostgresql)
+	deploymentID, pooled := metadata["deployment_id"], metadata["pooled"]
+	resolveStart := time.Now()
+	candidates, err := p.Resolver.Resolve(ctx, metadata, core.DatabaseTypePostgresql)
+	metrics.ResolutionDuration.With(deploymentID, pooled).Observe(time.Since(resolveStart).Seconds())
 	if err != nil {
+		outcome := "error"
+		if errors.Is(err, core.ErrBackendNotFound) {
+			outcome = "not_found"
+		}
+		metrics.Resolutions.With(deploymentID, pooled, outcome).Inc()
 		logger.Error("Resolution failed", "error", err, "remote_addr", clientConn.RemoteAddr())
 		_ = p.sendErrorResponse(clientConn, &ErrorResponse{
 			Severity: "FATAL",
@@ -87,18 +150,35 @@ func (p *PostgresProxy) HandleConnection(clientConn net.Conn) {
 		return
 	}
 
-	// 3. Dial Backend
-	backendConn, err := net.Dial("tcp", backendAddr)
+	metrics.Resolutions.With(deploymentID, pooled, "success").Inc()
+
+	// Routes may restrict which client addresses can use their backends
+	candidates = allowedCandidates(candidates, clientConn.RemoteAddr())
+	if len(candidates) == 0 {
+		logger.Warn("Client address not allowed", "deployment_id", deploymentID, "remote_addr", clientConn.RemoteAddr())
+		_ = p.sendErrorResponse(clientConn, &ErrorResponse{
+			Severity: "FATAL",
+			Code:     "28000", // invalid_authorization_specification
+			Message:  fmt.Sprintf("client address not allowed for deployment %s", deploymentID),
+		})
+		return
+	}
+
+	// 3. Dial Backend (PROXY header and TLS negotiation included), failing over across candidates
+	dialStart := time.Now()
+	backend, backendConn, err := p.connectCandidates(candidates, clientConn)
 	if err != nil {
-		logger.Error("Dial failed", "backend_addr", backendAddr, "error", err, "remote_addr", clientConn.RemoteAddr())
+		metrics.BackendDialDuration.With(deploymentID, pooled, "error").Observe(time.Since(dialStart).Seconds())
+		logger.Error("Dial failed", "deployment_id", deploymentID, "error", err, "remote_addr", clientConn.RemoteAddr())
 		_ = p.sendErrorResponse(clientConn, &ErrorResponse{
 			Severity: "FATAL",
 			Code:     "08001",
-			Message:  fmt.Sprintf("failed to connect to backend %s: %v", backendAddr, err),
+			Message:  fmt.Sprintf("failed to connect to backend: %v", err),
 		})
 		return
 	}
 	defer backendConn.Close()
+	metrics.BackendDialDuration.With(deploymentID, pooled, "success").Observe(time.Since(dialStart).Seconds())
 
 	// 4. Forward Startup Message
 	if _, err := backendConn.Write(rawStartupMsg); err != nil {
@@ -107,45 +187,129 @@ func (p *PostgresProxy) HandleConnection(clientConn net.Conn) {
 	}
 
 	// 5. Pipe Data
+	// Whichever side finishes first closes the other one, so a client that is
+	// force-closed during shutdown also tears down its backend session.
+	sessionStart := time.Now()
+	metrics.ActiveSessions.With(deploymentID, pooled).Inc()
+	defer func() {
+		metrics.ActiveSessions.With(deploymentID, pooled).Dec()
+		metrics.SessionDuration.With(deploymentID, pooled).Observe(time.Since(sessionStart).Seconds())
+	}()
+	if p.Connections != nil {
+		p.Connections.Acquire(backend.Key())
+		defer p.Connections.Release(backend.Key())
+	}
+
+	toBackend := &metrics.CountingWriter{W: backendConn, Counter: metrics.BytesTransferred.With(deploymentID, pooled, metrics.DirectionClientToBackend)}
+	toClient := &metrics.CountingWriter{W: clientConn, Counter: metrics.BytesTransferred.With(deploymentID, pooled, metrics.DirectionBackendToClient)}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
 		defer wg.Done()
-		io.Copy(backendConn, clientConn)
+		io.Copy(toBackend, clientConn)
+		backendConn.Close()
 	}()
 
 	go func() {
 		defer wg.Done()
-		io.Copy(clientConn, backendConn)
+		// Capture BackendKeyData during session setup so cancel requests can be routed
+		key, err := relayStartupResponse(toClient, backendConn)
+		if err == nil {
+			if key != nil {
+				p.registerCancelKey(*key, backend)
+				defer p.unregisterCancelKey(*key)
+			}
+			io.Copy(toClient, backendConn)
+		}
+		clientConn.Close()
 	}()
 
 	wg.Wait()
 }
 
-// handshake performs the initial protocol handshake and returns metadata, the (potentially wrapped) connection, and the raw startup message bytes.
-func (p *PostgresProxy) handshake(conn net.Conn) (core.RoutingMetadata, net.Conn, []byte, error) {
-	// Read message length (4 bytes)
-	header := make([]byte, 4)
-	if _, err := io.ReadFull(conn, header); err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to read message length: %w", err)
+func (p *PostgresProxy) registerCancelKey(key core.CancelKey, backend core.Backend) {
+	if p.CancelKeys == nil {
+		return
 	}
-
-	length := int32(binary.BigEndian.Uint32(header))
-	if length < 4 {
-		return nil, nil, nil, fmt.Errorf("invalid message length: %d", length)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.CancelKeys.Register(ctx, key, backend); err != nil {
+		logger.Warn("Failed to register cancel key", "pid", key.ProcessID, "backend_addr", backend.Address, "error", err)
 	}
+}
 
-	// Read message body
-	payload := make([]byte, length-4)
-	if _, err := io.ReadFull(conn, payload); err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to read message body: %w", err)
+func (p *PostgresProxy) unregisterCancelKey(key core.CancelKey) {
+	if p.CancelKeys == nil {
+		return
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.CancelKeys.Unregister(ctx, key); err != nil {
+		logger.Warn("Failed to unregister cancel key", "pid", key.ProcessID, "error", err)
+	}
+}
+
+// handshake performs the initial protocol handshake and returns metadata, the (potentially wrapped) connection, and the raw startup message bytes.
+// It answers the negotiation requests that may precede the StartupMessage (GSSENCRequest, SSLRequest)
+// and returns a *cancelRequest error when the client only wants to cancel a query.
+func (p *PostgresProxy) handshake(conn net.Conn) (core.RoutingMetadata, net.Conn, []byte, error) {
+	// As in PostgreSQL, each request may be sent once, and GSSENC only before TLS
+	// was accepted: after an 'N' to the SSLRequest the client may still try GSSAPI.
+	var sslDone, gssDone, tlsAccepted bool
+
+	for {
+		// Read message length (4 bytes)
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read message length: %w", err)
+		}
+
+		// PostgreSQL 17 direct SSL (sslnegotiation=direct): the client opens with a
+		// TLS ClientHello instead of an SSLRequest. No valid length starts with 0x16.
+		if header[0] == tlsRecordTypeHandshake && !sslDone && !gssDone {
+			tlsConn, err := p.acceptDirectTLS(conn, header)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			conn = tlsConn
+			sslDone, tlsAccepted = true, true
+			continue
+		}
+
+		payload, err := readStartupPayload(conn, header)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		code := binary.BigEndian.Uint32(payload[0:4])
+		switch code {
+		case cancelRequestCode:
+			req, err := parseCancelRequest(header, payload)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			return nil, nil, nil, req
+
+		case gssEncRequestCode:
+			// GSSAPI encryption is not supported; libpq falls back to SSL or plain text
+			if gssDone || tlsAccepted {
+				return nil, nil, nil, p.rejectHandshake(conn, "08P01", "unexpected GSSENCRequest after encryption negotiation")
+			}
+			if _, err := conn.Write([]byte{'N'}); err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to write GSSENC rejection response: %w", err)
+			}
+			logger.Info("GSSENC request rejected - GSSAPI encryption is not supported", "remote_addr", conn.RemoteAddr())
+			gssDone = true
+			continue
+
+		case sslRequestCode:
+			if sslDone {
+				return nil, nil, nil, p.rejectHandshake(conn, "08P01", "unexpected SSLRequest after encryption negotiation")
+			}
+			sslDone = true
 
-	// Check for SSLRequest
-	if len(payload) >= 4 {
-		code := int32(binary.BigEndian.Uint32(payload[0:4]))
-		if code == sslRequestCode {
 			// Check if TLS is configured
 			if p.TLSConfig == nil {
 				// Send 'N' to reject SSL (TLS disabled)
@@ -154,7 +318,7 @@ func (p *PostgresProxy) handshake(conn net.Conn) (core.RoutingMetadata, net.Conn
 				}
 				logger.Info("SSL request rejected - TLS is disabled", "remote_addr", conn.RemoteAddr())
 				// Continue reading the next message (StartupMessage without SSL)
-				return p.handshake(conn)
+				continue
 			}
 
 			// Send 'S' to accept SSL
@@ -172,23 +336,103 @@ func (p *PostgresProxy) handshake(conn net.Conn) (core.RoutingMetadata, net.Conn
 				})
 				return nil, nil, nil, fmt.Errorf("tls handshake failed: %w", err)
 			}
+			logTLSHandshake(tlsConn, "sslrequest")
 
-			state := tlsConn.ConnectionState()
-			logger.Info("TLS Handshake successful",
-				"protocol", tlsVersionName(state.Version),
-				"cipher_suite", tls.CipherSuiteName(state.CipherSuite),
-				"remote_addr", conn.RemoteAddr())
+			// Continue with the StartupMessage from the encrypted stream
+			conn = tlsConn
+			tlsAccepted = true
+			continue
+		}
 
-			// Recursively parse the StartupMessage from the encrypted stream
-			return p.handshake(tlsConn)
+		// Anything else in the negotiation range is a request code we don't know
+		if code>>16 == negotiationMajorVersion {
+			return nil, nil, nil, p.rejectHandshake(conn, "08P01",
+				fmt.Sprintf("unsupported negotiation request code %d", code))
+		}
+		if code>>16 != protocolMajorVersion {
+			return nil, nil, nil, p.rejectHandshake(conn, "0A000",
+				fmt.Sprintf("unsupported frontend protocol %d.%d: server supports 3.0", code>>16, code&0xFFFF))
 		}
+
+		return p.parseStartupMessage(conn, payload)
+	}
+}
+
+// readStartupPayload reads the body of a length-prefixed startup packet.
+// The returned payload always holds at least the 4-byte protocol version / request code.
+func readStartupPayload(conn net.Conn, header []byte) ([]byte, error) {
+	length := binary.BigEndian.Uint32(header)
+	if length < 8 || length > maxStartupPacketLength {
+		return nil, fmt.Errorf("invalid message length: %d", length)
+	}
+
+	// Read message body
+	payload := make([]byte, length-4)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return nil, fmt.Errorf("failed to read message body: %w", err)
+	}
+	return payload, nil
+}
+
+// acceptDirectTLS completes a direct TLS handshake whose first bytes were already consumed.
+// As in PostgreSQL, direct TLS requires the client to negotiate the "postgresql" ALPN protocol.
+func (p *PostgresProxy) acceptDirectTLS(conn net.Conn, consumed []byte) (net.Conn, error) {
+	if p.TLSConfig == nil {
+		return nil, fmt.Errorf("direct TLS connection rejected - TLS is disabled")
+	}
+
+	tlsConn := tls.Server(&prefixConn{Conn: conn, prefix: consumed}, p.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("direct tls handshake failed: %w", err)
 	}
 
-	// Parse StartupMessage
-	if len(payload) < 4 {
-		return nil, nil, nil, fmt.Errorf("payload too short")
+	if protocol := tlsConn.ConnectionState().NegotiatedProtocol; protocol != alpnProtocol {
+		tlsConn.Close()
+		return nil, fmt.Errorf("direct TLS connection rejected - ALPN protocol %q, expected %q", protocol, alpnProtocol)
 	}
 
+	logTLSHandshake(tlsConn, "direct")
+	return tlsConn, nil
+}
+
+func logTLSHandshake(tlsConn *tls.Conn, negotiation string) {
+	state := tlsConn.ConnectionState()
+	metrics.TLSHandshakes.With(tlsVersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), negotiation).Inc()
+	logger.Info("TLS Handshake successful",
+		"protocol", tlsVersionName(state.Version),
+		"cipher_suite", tls.CipherSuiteName(state.CipherSuite),
+		"negotiation", negotiation,
+		"remote_addr", tlsConn.RemoteAddr())
+}
+
+// prefixConn replays bytes already consumed from the connection before reading from it.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+// rejectHandshake sends a FATAL ErrorResponse and returns the matching error.
+func (p *PostgresProxy) rejectHandshake(conn net.Conn, code, message string) error {
+	_ = p.sendErrorResponse(conn, &ErrorResponse{
+		Severity: "FATAL",
+		Code:     code,
+		Message:  message,
+	})
+	return errors.New(message)
+}
+
+// parseStartupMessage extracts routing metadata from a StartupMessage payload
+// and rebuilds the message that is forwarded to the backend.
+func (p *PostgresProxy) parseStartupMessage(conn net.Conn, payload []byte) (core.RoutingMetadata, net.Conn, []byte, error) {
 	params := make(map[string]string)
 	buf := bytes.NewBuffer(payload[4:]) // Skip protocol version
 
@@ -216,69 +460,58 @@ func (p *PostgresProxy) handshake(conn net.Conn) (core.RoutingMetadata, net.Conn
 		logger.Info("StartupMessage param", "key", key, "value", value, "remote_addr", conn.RemoteAddr())
 	}
 
-	// Parse username to extract deployment_id and pool status
-	// Format: username.deployment_id[.pool]
-	// Examples:
-	//   alice.db-prod.pool     → username=alice, deployment_id=db-prod, pooled=true
-	//   bob.team-1992252154561 → username=bob, deployment_id=team-1992252154561, pooled=false
-	if user, ok := params["user"]; ok {
-		logger.Info("Connection requested", "user", user, "remote_addr", conn.RemoteAddr())
-		parts := strings.Split(user, ".")
-		if len(parts) >= 2 {
-			if parts[len(parts)-1] == "pool" {
-				params["pooled"] = "true"
-				if len(parts) >= 3 {
-					params["deployment_id"] = parts[len(parts)-2]
-					params["username"] = strings.Join(parts[:len(parts)-2], ".")
-				}
-			} else {
-				params["pooled"] = "false"
-				params["deployment_id"] = parts[len(parts)-1]
-				params["username"] = strings.Join(parts[:len(parts)-1], ".")
-			}
-		} else {
-			params["pooled"] = "false"
+	// Run the routing extractors in their configured order; the first match wins.
+	// A matching extractor may rewrite params (e.g. "alice.db-prod.pool" → "alice"),
+	// and the rewritten params are what the backend receives.
+	req := &core.RoutingRequest{Params: params}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		req.ServerName = tlsConn.ConnectionState().ServerName
+	}
+
+	var target core.RoutingTarget
+	var matchedBy string
+	for _, extractor := range p.RoutingExtractors {
+		if t, ok := extractor.Extract(req); ok {
+			target, matchedBy = t, extractor.Name()
+			break
 		}
 	}
 
-	// Default database to postgres if not provided OR if it equals the original user
-	// Some PostgreSQL clients (like psql) automatically use username as database when not specified
-	// This causes issues when username is "postgres.team-1992252154561" and gets used as database name
-	// We detect this case and default to "postgres" database instead
-	originalUser := params["user"]
-	if dbName, ok := params["database"]; !ok || dbName == "" || dbName == originalUser {
-		params["database"] = "postgres"
-		logger.Info("Database defaulted to postgres", "original_db", dbName, "remote_addr", conn.RemoteAddr())
+	metadata := make(core.RoutingMetadata, len(params)+3)
+	for k, v := range params {
+		metadata[k] = v
 	}
+	if matchedBy != "" {
+		metadata["deployment_id"] = target.DeploymentID
+		metadata["pooled"] = strconv.FormatBool(target.Pooled)
+		metadata["routing_extractor"] = matchedBy
+		logger.Info("Connection requested",
+			"deployment_id", target.DeploymentID,
+			"pooled", target.Pooled,
+			"extractor", matchedBy,
+			"server_name", req.ServerName,
+			"user", params["user"],
+			"database", params["database"],
+			"remote_addr", conn.RemoteAddr())
+	} else {
+		logger.Warn("No routing extractor matched the connection",
+			"server_name", req.ServerName,
+			"user", params["user"],
+			"database", params["database"],
+			"remote_addr", conn.RemoteAddr())
+	}
+
+	// target_session_attrs is consumed by the resolver; PostgreSQL would reject it as an unknown setting
+	delete(params, core.MetadataTargetSessionAttrs)
 
-	// Always rebuild startup message with parsed params
+	// Always rebuild startup message with the (possibly rewritten) params
 	// Every PostgreSQL connection performs a fresh handshake, so we rebuild the StartupMessage
 	// to send the correct username (without deployment_id/pool suffix) and database to the backend
 	protocolVersion := binary.BigEndian.Uint32(payload[0:4])
-	buildParams := make(map[string]string)
-
-	// Copy all params except internal metadata (user will be set separately)
-	// Exclude: deployment_id, pooled, username (internal routing metadata)
-	// Include: database, client_encoding, application_name, etc.
-	for k, v := range params {
-		if k != "deployment_id" && k != "pooled" && k != "username" && k != "user" {
-			buildParams[k] = v
-		}
-	}
-
-	// Use parsed username (without deployment_id suffix) or fallback to original
-	// Backend expects: "alice" not "alice.db-prod.pool"
-	if username, ok := params["username"]; ok && username != "" {
-		buildParams["user"] = username
-		logger.Info("Using parsed username", "username", username, "database", buildParams["database"], "remote_addr", conn.RemoteAddr())
-	} else if originalUser, ok := params["user"]; ok {
-		buildParams["user"] = originalUser
-		logger.Info("Using original username", "user", originalUser, "database", buildParams["database"], "remote_addr", conn.RemoteAddr())
-	}
 
 	// Rebuild the binary StartupMessage packet with modified parameters
-	rawStartupMsg := rebuildStartupMessage(protocolVersion, buildParams)
-	return core.RoutingMetadata(params), conn, rawStartupMsg, nil
+	rawStartupMsg := rebuildStartupMessage(protocolVersion, params)
+	return metadata, conn, rawStartupMsg, nil
 }
 
 func rebuildStartupMessage(protocolVersion uint32, params map[string]string) []byte {
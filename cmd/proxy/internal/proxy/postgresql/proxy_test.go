@@ -0,0 +1,286 @@
+package postgresql_proxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/utils"
+)
+
+// handshakeResult is what handshake returned on the proxy side of the pipe.
+type handshakeResult struct {
+	metadata map[string]string
+	conn     net.Conn
+	err      error
+}
+
+func TestHandshake(t *testing.T) {
+	serverTLS := testServerTLSConfig(t)
+
+	tests := []struct {
+		name string
+		tls  bool // Proxy has TLS configured
+		// client drives the client side and returns the connection to send the StartupMessage on,
+		// or nil if the handshake is expected to end without one
+		client  func(t *testing.T, conn net.Conn) net.Conn
+		wantTLS bool
+		wantErr bool
+	}{
+		{
+			name:   "plain startup",
+			client: func(t *testing.T, conn net.Conn) net.Conn { return conn },
+		},
+		{
+			name: "SSLRequest accepted",
+			tls:  true,
+			client: func(t *testing.T, conn net.Conn) net.Conn {
+				sendRequest(t, conn, sslRequestCode)
+				expectByte(t, conn, 'S')
+				return clientTLS(t, conn, nil)
+			},
+			wantTLS: true,
+		},
+		{
+			name: "SSLRequest rejected without TLS",
+			client: func(t *testing.T, conn net.Conn) net.Conn {
+				sendRequest(t, conn, sslRequestCode)
+				expectByte(t, conn, 'N')
+				return conn
+			},
+		},
+		{
+			name: "GSSENCRequest then SSLRequest",
+			tls:  true,
+			client: func(t *testing.T, conn net.Conn) net.Conn {
+				sendRequest(t, conn, gssEncRequestCode)
+				expectByte(t, conn, 'N')
+				sendRequest(t, conn, sslRequestCode)
+				expectByte(t, conn, 'S')
+				return clientTLS(t, conn, nil)
+			},
+			wantTLS: true,
+		},
+		{
+			name: "GSSENCRequest after rejected SSLRequest",
+			client: func(t *testing.T, conn net.Conn) net.Conn {
+				sendRequest(t, conn, sslRequestCode)
+				expectByte(t, conn, 'N')
+				sendRequest(t, conn, gssEncRequestCode)
+				expectByte(t, conn, 'N')
+				return conn
+			},
+		},
+		{
+			name: "GSSENCRequest after accepted SSLRequest",
+			tls:  true,
+			client: func(t *testing.T, conn net.Conn) net.Conn {
+				sendRequest(t, conn, sslRequestCode)
+				expectByte(t, conn, 'S')
+				tlsConn := clientTLS(t, conn, nil)
+				sendRequest(t, tlsConn, gssEncRequestCode)
+				expectError(t, tlsConn, "08P01")
+				return nil
+			},
+			wantErr: true,
+		},
+		{
+			name: "second SSLRequest",
+			client: func(t *testing.T, conn net.Conn) net.Conn {
+				sendRequest(t, conn, sslRequestCode)
+				expectByte(t, conn, 'N')
+				sendRequest(t, conn, sslRequestCode)
+				expectError(t, conn, "08P01")
+				return nil
+			},
+			wantErr: true,
+		},
+		{
+			name: "direct TLS with ALPN",
+			tls:  true,
+			client: func(t *testing.T, conn net.Conn) net.Conn {
+				return clientTLS(t, conn, []string{alpnProtocol})
+			},
+			wantTLS: true,
+		},
+		{
+			name: "direct TLS without ALPN",
+			tls:  true,
+			client: func(t *testing.T, conn net.Conn) net.Conn {
+				tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+				tlsConn.Handshake() // May fail or succeed depending on timing; the proxy rejects either way
+				go io.Copy(io.Discard, tlsConn)
+				return nil
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsupported protocol version",
+			client: func(t *testing.T, conn net.Conn) net.Conn {
+				sendRequest(t, conn, 2<<16)
+				expectError(t, conn, "0A000")
+				return nil
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown negotiation request code",
+			client: func(t *testing.T, conn net.Conn) net.Conn {
+				sendRequest(t, conn, 1234<<16|9999)
+				expectError(t, conn, "08P01")
+				return nil
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := &PostgresProxy{}
+			if tt.tls {
+				proxy.TLSConfig = serverTLS
+			}
+
+			result := runHandshake(t, proxy, func(conn net.Conn) {
+				if startupConn := tt.client(t, conn); startupConn != nil {
+					writeAll(t, startupConn, rebuildStartupMessage(3<<16, map[string]string{"user": "alice", "database": "app"}))
+				}
+			})
+
+			if tt.wantErr {
+				if result.err == nil {
+					t.Fatalf("handshake succeeded, want error")
+				}
+				return
+			}
+			if result.err != nil {
+				t.Fatalf("handshake failed: %v", result.err)
+			}
+			if result.metadata["user"] != "alice" || result.metadata["database"] != "app" {
+				t.Errorf("metadata = %v, want user=alice database=app", result.metadata)
+			}
+			if _, isTLS := result.conn.(*tls.Conn); isTLS != tt.wantTLS {
+				t.Errorf("TLS connection = %v, want %v", isTLS, tt.wantTLS)
+			}
+		})
+	}
+}
+
+func TestHandshakeCancelRequest(t *testing.T) {
+	packet := make([]byte, 16)
+	binary.BigEndian.PutUint32(packet[0:4], 16)
+	binary.BigEndian.PutUint32(packet[4:8], cancelRequestCode)
+	binary.BigEndian.PutUint32(packet[8:12], 4242)
+	copy(packet[12:], "skey")
+
+	result := runHandshake(t, &PostgresProxy{}, func(conn net.Conn) { writeAll(t, conn, packet) })
+
+	var req *cancelRequest
+	if !errors.As(result.err, &req) {
+		t.Fatalf("handshake error = %v, want a cancel request", result.err)
+	}
+	if req.key.ProcessID != 4242 || req.key.SecretKey != "skey" {
+		t.Errorf("cancel key = %+v, want pid 4242 and secret skey", req.key)
+	}
+	if !bytes.Equal(req.raw, packet) {
+		t.Errorf("raw packet = %x, want %x", req.raw, packet)
+	}
+}
+
+// runHandshake runs handshake on one end of a pipe while client drives the other.
+func runHandshake(t *testing.T, proxy *PostgresProxy, client func(conn net.Conn)) handshakeResult {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	deadline := time.Now().Add(5 * time.Second)
+	serverConn.SetDeadline(deadline)
+	clientConn.SetDeadline(deadline)
+	t.Cleanup(func() {
+		serverConn.Close()
+		clientConn.Close()
+	})
+
+	done := make(chan handshakeResult, 1)
+	go func() {
+		metadata, conn, _, err := proxy.handshake(serverConn)
+		done <- handshakeResult{metadata: metadata, conn: conn, err: err}
+	}()
+
+	client(clientConn)
+	return <-done
+}
+
+func testServerTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	certPEM, keyPEM, err := utils.GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("generate certificate: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("load certificate: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{alpnProtocol}}
+}
+
+func clientTLS(t *testing.T, conn net.Conn, nextProtos []string) net.Conn {
+	t.Helper()
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, NextProtos: nextProtos})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("client TLS handshake: %v", err)
+	}
+	return tlsConn
+}
+
+func sendRequest(t *testing.T, conn net.Conn, code uint32) {
+	t.Helper()
+	packet := make([]byte, 8)
+	binary.BigEndian.PutUint32(packet[0:4], 8)
+	binary.BigEndian.PutUint32(packet[4:8], code)
+	writeAll(t, conn, packet)
+}
+
+func writeAll(t *testing.T, conn net.Conn, data []byte) {
+	t.Helper()
+	if _, err := conn.Write(data); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func expectByte(t *testing.T, conn net.Conn, want byte) {
+	t.Helper()
+	got := make([]byte, 1)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if got[0] != want {
+		t.Fatalf("response = %q, want %q", got[0], want)
+	}
+}
+
+// expectError reads an ErrorResponse and checks its SQLSTATE.
+func expectError(t *testing.T, conn net.Conn, wantCode string) {
+	t.Helper()
+	expectByte(t, conn, 'E')
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatalf("read ErrorResponse length: %v", err)
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header)-4)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatalf("read ErrorResponse: %v", err)
+	}
+	for _, field := range bytes.Split(body, []byte{0}) {
+		if len(field) > 0 && field[0] == 'C' {
+			if code := string(field[1:]); code != wantCode {
+				t.Fatalf("SQLSTATE = %s, want %s", code, wantCode)
+			}
+			return
+		}
+	}
+	t.Fatalf("ErrorResponse without SQLSTATE: %q", body)
+}
@@ -0,0 +1,211 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrNoHeader is returned when the connection does not start with a PROXY protocol header.
+var ErrNoHeader = errors.New("proxyproto: missing PROXY protocol header")
+
+const (
+	// v1 headers are at most 107 bytes including the trailing CRLF.
+	v1MaxLength = 107
+
+	v2HeaderLength = 16
+	v2Version      = 0x20
+	v2CmdLocal     = 0x00
+	v2CmdProxy     = 0x01
+
+	v2FamilyTCP4 = 0x11
+	v2FamilyTCP6 = 0x21
+
+	v2AddrLengthTCP4 = 12
+	v2AddrLengthTCP6 = 36
+)
+
+var (
+	v1Signature = []byte("PROXY ")
+	v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+)
+
+// Header is a decoded PROXY protocol header.
+// SourceAddr and DestinationAddr are nil for LOCAL (v2) and UNKNOWN (v1) headers,
+// in which case the real connection addresses must be used.
+type Header struct {
+	Version         int
+	SourceAddr      net.Addr
+	DestinationAddr net.Addr
+}
+
+// ReadHeader reads a v1 or v2 PROXY protocol header from r.
+// It returns ErrNoHeader if the stream does not start with a PROXY signature.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	peek, err := r.Peek(len(v1Signature))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol signature: %w", err)
+	}
+	if bytes.Equal(peek, v1Signature) {
+		return readV1(r)
+	}
+
+	peek, err = r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PROXY protocol signature: %w", err)
+	}
+	if bytes.Equal(peek, v2Signature) {
+		return readV2(r)
+	}
+
+	return nil, ErrNoHeader
+}
+
+// readV1 parses the human-readable format: "PROXY TCP4 <src> <dst> <sport> <dport>\r\n".
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PROXY v1 header: %w", err)
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("PROXY v1 header exceeds %d bytes", v1MaxLength)
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("malformed PROXY v1 header")
+	}
+
+	header := &Header{Version: 1}
+	switch fields[1] {
+	case "UNKNOWN":
+		return header, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v1 protocol: %s", fields[1])
+	}
+
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("malformed PROXY v1 header")
+	}
+
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 source address: %w", err)
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 destination address: %w", err)
+	}
+
+	header.SourceAddr = src
+	header.DestinationAddr = dst
+	return header, nil
+}
+
+func parseV1Addr(ipStr, portStr string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil, fmt.Errorf("bad ip %q", ipStr)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port %q", portStr)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 parses the binary format. TLVs following the address block are skipped.
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, v2HeaderLength)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY v2 header: %w", err)
+	}
+
+	verCmd := fixed[12]
+	if verCmd&0xF0 != v2Version {
+		return nil, fmt.Errorf("unsupported PROXY v2 version: %#x", verCmd>>4)
+	}
+	family := fixed[13]
+	length := int(binary.BigEndian.Uint16(fixed[14:16]))
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("failed to read PROXY v2 addresses: %w", err)
+	}
+
+	header := &Header{Version: 2}
+	switch verCmd & 0x0F {
+	case v2CmdLocal:
+		// Health checks from the load balancer itself: keep the real addresses
+		return header, nil
+	case v2CmdProxy:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command: %#x", verCmd&0x0F)
+	}
+
+	switch family {
+	case v2FamilyTCP4:
+		if length < v2AddrLengthTCP4 {
+			return nil, fmt.Errorf("PROXY v2 IPv4 address block too short: %d", length)
+		}
+		header.SourceAddr = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		header.DestinationAddr = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case v2FamilyTCP6:
+		if length < v2AddrLengthTCP6 {
+			return nil, fmt.Errorf("PROXY v2 IPv6 address block too short: %d", length)
+		}
+		header.SourceAddr = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		header.DestinationAddr = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	default:
+		// UNSPEC, UDP and UNIX sockets carry no usable TCP client address
+	}
+
+	return header, nil
+}
+
+// WriteV2 writes a binary PROXY v2 header announcing src as the client and dst
+// as the address it connected to. Non-TCP addresses produce a LOCAL header.
+func WriteV2(w io.Writer, src, dst net.Addr) error {
+	header := make([]byte, v2HeaderLength, v2HeaderLength+v2AddrLengthTCP6)
+	copy(header, v2Signature)
+
+	srcTCP, srcOK := src.(*net.TCPAddr)
+	dstTCP, dstOK := dst.(*net.TCPAddr)
+	if !srcOK || !dstOK {
+		header[12] = v2Version | v2CmdLocal
+		_, err := w.Write(header)
+		return err
+	}
+
+	header[12] = v2Version | v2CmdProxy
+	if src4, dst4 := srcTCP.IP.To4(), dstTCP.IP.To4(); src4 != nil && dst4 != nil {
+		header[13] = v2FamilyTCP4
+		binary.BigEndian.PutUint16(header[14:16], v2AddrLengthTCP4)
+		header = append(header, src4...)
+		header = append(header, dst4...)
+	} else {
+		header[13] = v2FamilyTCP6
+		binary.BigEndian.PutUint16(header[14:16], v2AddrLengthTCP6)
+		header = append(header, srcTCP.IP.To16()...)
+		header = append(header, dstTCP.IP.To16()...)
+	}
+	header = binary.BigEndian.AppendUint16(header, uint16(srcTCP.Port))
+	header = binary.BigEndian.AppendUint16(header, uint16(dstTCP.Port))
+
+	_, err := w.Write(header)
+	return err
+}
@@ -0,0 +1,162 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+var (
+	testSrc4 = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51234}
+	testDst4 = &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5432}
+	testSrc6 = &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51234}
+	testDst6 = &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 5432}
+)
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    *Header
+		wantErr error // nil = any error when want is nil
+	}{
+		{
+			name:  "v1 TCP4",
+			input: []byte("PROXY TCP4 192.0.2.1 10.0.0.1 51234 5432\r\n"),
+			want:  &Header{Version: 1, SourceAddr: testSrc4, DestinationAddr: testDst4},
+		},
+		{
+			name:  "v1 TCP6",
+			input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 51234 5432\r\n"),
+			want:  &Header{Version: 1, SourceAddr: testSrc6, DestinationAddr: testDst6},
+		},
+		{
+			name:  "v1 UNKNOWN",
+			input: []byte("PROXY UNKNOWN\r\n"),
+			want:  &Header{Version: 1},
+		},
+		{name: "v1 truncated", input: []byte("PROXY TCP4 192.0.2.1 10.0.0.1")},
+		{name: "v1 oversized", input: []byte("PROXY TCP4 " + strings.Repeat("1", v1MaxLength) + "\r\n")},
+		{name: "v1 bad address", input: []byte("PROXY TCP4 192.0.2.x 10.0.0.1 51234 5432\r\n")},
+		{name: "v1 bad port", input: []byte("PROXY TCP4 192.0.2.1 10.0.0.1 51234 65536\r\n")},
+		{name: "v1 missing fields", input: []byte("PROXY TCP4 192.0.2.1 10.0.0.1\r\n")},
+		{name: "v1 unsupported protocol", input: []byte("PROXY UDP4 192.0.2.1 10.0.0.1 51234 5432\r\n")},
+		{
+			name:  "v2 TCP4",
+			input: writeV2(t, testSrc4, testDst4),
+			want:  &Header{Version: 2, SourceAddr: testSrc4, DestinationAddr: testDst4},
+		},
+		{
+			name:  "v2 TCP6",
+			input: writeV2(t, testSrc6, testDst6),
+			want:  &Header{Version: 2, SourceAddr: testSrc6, DestinationAddr: testDst6},
+		},
+		{
+			name:  "v2 TCP4 with TLVs",
+			input: v2Header(v2Version|v2CmdProxy, v2FamilyTCP4, append(v2Addresses4(), 0x04, 0x00, 0x01, 0xFF)),
+			want:  &Header{Version: 2, SourceAddr: testSrc4, DestinationAddr: testDst4},
+		},
+		{
+			name:  "v2 LOCAL",
+			input: v2Header(v2Version|v2CmdLocal, 0x00, nil),
+			want:  &Header{Version: 2},
+		},
+		{
+			name:  "v2 LOCAL with addresses",
+			input: v2Header(v2Version|v2CmdLocal, v2FamilyTCP4, v2Addresses4()),
+			want:  &Header{Version: 2},
+		},
+		{
+			name:  "v2 UNSPEC",
+			input: v2Header(v2Version|v2CmdProxy, 0x00, nil),
+			want:  &Header{Version: 2},
+		},
+		{name: "v2 truncated fixed header", input: v2Signature},
+		{name: "v2 truncated addresses", input: writeV2(t, testSrc4, testDst4)[:v2HeaderLength+6]},
+		{name: "v2 address block too short", input: v2Header(v2Version|v2CmdProxy, v2FamilyTCP4, v2Addresses4()[:8])},
+		{name: "v2 unsupported version", input: v2Header(0x30|v2CmdProxy, v2FamilyTCP4, v2Addresses4())},
+		{name: "v2 unsupported command", input: v2Header(v2Version|0x02, v2FamilyTCP4, v2Addresses4())},
+		{name: "no header", input: []byte("\x00\x00\x00\x10\x00\x03\x00\x00user\x00app\x00\x00"), wantErr: ErrNoHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Whatever follows a valid header must be left for the protocol; invalid
+			// ones end the stream, or truncated headers would read the data as theirs
+			input := tt.input
+			if tt.want != nil {
+				input = append(input, "startup"...)
+			}
+			r := bufio.NewReader(bytes.NewReader(input))
+			header, err := ReadHeader(r)
+
+			if tt.want == nil {
+				if err == nil {
+					t.Fatalf("ReadHeader() = %+v, want error", header)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("ReadHeader() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadHeader() failed: %v", err)
+			}
+			if header.Version != tt.want.Version ||
+				!sameAddr(header.SourceAddr, tt.want.SourceAddr) ||
+				!sameAddr(header.DestinationAddr, tt.want.DestinationAddr) {
+				t.Errorf("ReadHeader() = %+v, want %+v", header, tt.want)
+			}
+			if rest, _ := io.ReadAll(r); string(rest) != "startup" {
+				t.Errorf("data after the header = %q, want %q", rest, "startup")
+			}
+		})
+	}
+}
+
+func TestWriteV2Local(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteV2(&buf, &net.UnixAddr{Name: "/tmp/s", Net: "unix"}, testDst4); err != nil {
+		t.Fatalf("WriteV2() failed: %v", err)
+	}
+	if got := buf.Bytes(); len(got) != v2HeaderLength || got[12] != v2Version|v2CmdLocal {
+		t.Errorf("WriteV2() = %x, want a LOCAL header without addresses", got)
+	}
+}
+
+func writeV2(t *testing.T, src, dst net.Addr) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteV2(&buf, src, dst); err != nil {
+		t.Fatalf("WriteV2() failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// v2Header builds a v2 header with the given version/command byte, family and payload.
+func v2Header(verCmd, family byte, payload []byte) []byte {
+	header := append([]byte{}, v2Signature...)
+	header = append(header, verCmd, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// v2Addresses4 is the IPv4 address block for testSrc4 and testDst4.
+func v2Addresses4() []byte {
+	block := append([]byte{}, testSrc4.IP.To4()...)
+	block = append(block, testDst4.IP.To4()...)
+	block = binary.BigEndian.AppendUint16(block, uint16(testSrc4.Port))
+	return binary.BigEndian.AppendUint16(block, uint16(testDst4.Port))
+}
+
+func sameAddr(a, b net.Addr) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.String() == b.String()
+}
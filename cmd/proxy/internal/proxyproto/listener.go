@@ -0,0 +1,159 @@
+package proxyproto
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Listener wraps a net.Listener and decodes PROXY protocol headers sent by
+// trusted load balancers, so RemoteAddr reports the original client.
+type Listener struct {
+	net.Listener
+
+	// TrustedNetworks lists the peers that may send a header. Empty trusts no peer.
+	TrustedNetworks []*net.IPNet
+
+	// HeaderTimeout bounds how long a trusted peer may take to send its header.
+	HeaderTimeout time.Duration
+}
+
+// NewListener wraps inner. trustedCIDRs accepts CIDRs ("10.0.0.0/8") or plain IPs.
+func NewListener(inner net.Listener, trustedCIDRs []string, headerTimeout time.Duration) (*Listener, error) {
+	networks, err := ParseCIDRs(trustedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{
+		Listener:        inner,
+		TrustedNetworks: networks,
+		HeaderTimeout:   headerTimeout,
+	}, nil
+}
+
+// Accept returns a connection whose header is decoded lazily on first use,
+// so a slow peer never blocks the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{
+		Conn:          conn,
+		trusted:       l.isTrusted(conn.RemoteAddr()),
+		headerTimeout: l.HeaderTimeout,
+	}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range l.TrustedNetworks {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection accepted by Listener.
+// Trusted peers must send a header; untrusted peers are passed through untouched,
+// so a client cannot spoof its address by sending a header itself.
+type Conn struct {
+	net.Conn
+
+	trusted       bool
+	headerTimeout time.Duration
+
+	once   sync.Once
+	reader *bufio.Reader
+	header *Header
+	err    error
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.reader == nil {
+		return c.Conn.Read(b)
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY header when present.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && c.header.SourceAddr != nil {
+		return c.header.SourceAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client originally connected to when present.
+func (c *Conn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && c.header.DestinationAddr != nil {
+		return c.header.DestinationAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// Header returns the decoded PROXY header, or nil if none was received.
+func (c *Conn) Header() *Header {
+	c.once.Do(c.readHeader)
+	return c.header
+}
+
+func (c *Conn) readHeader() {
+	if !c.trusted {
+		return
+	}
+
+	if c.headerTimeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+	}
+
+	c.reader = bufio.NewReader(c.Conn)
+	header, err := ReadHeader(c.reader)
+	if err != nil {
+		c.err = fmt.Errorf("PROXY protocol from %s: %w", c.Conn.RemoteAddr(), err)
+		return
+	}
+	c.header = header
+}
+
+// ParseCIDRs parses a list of CIDRs or plain IP addresses.
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", value)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			value = fmt.Sprintf("%s/%d", value, bits)
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %s", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,164 @@
+package proxyproto
+
+import (
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestListener(t *testing.T) {
+	proxyHeader := "PROXY TCP4 192.0.2.1 10.0.0.1 51234 5432\r\n"
+
+	tests := []struct {
+		name         string
+		trusted      []string
+		send         string
+		wantRemote   string // Empty = the real peer address
+		wantData     string
+		wantErr      error
+		wantNoHeader bool
+	}{
+		{
+			name:       "trusted peer with header",
+			trusted:    []string{"127.0.0.1"},
+			send:       proxyHeader + "startup",
+			wantRemote: "192.0.2.1:51234",
+			wantData:   "startup",
+		},
+		{
+			name:         "untrusted peer header passed through",
+			trusted:      []string{"10.0.0.0/8"},
+			send:         proxyHeader + "startup",
+			wantData:     proxyHeader + "startup",
+			wantNoHeader: true,
+		},
+		{
+			name:         "no trusted peers",
+			send:         proxyHeader + "startup",
+			wantData:     proxyHeader + "startup",
+			wantNoHeader: true,
+		},
+		{
+			name:     "trusted peer with LOCAL header",
+			trusted:  []string{"127.0.0.0/8"},
+			send:     string(v2Header(v2Version|v2CmdLocal, 0x00, nil)) + "startup",
+			wantData: "startup",
+		},
+		{
+			name:    "trusted peer without header",
+			trusted: []string{"127.0.0.0/8"},
+			send:    "\x00\x00\x00\x10\x00\x03\x00\x00user\x00app\x00\x00",
+			wantErr: ErrNoHeader,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listen: %v", err)
+			}
+			l, err := NewListener(inner, tt.trusted, time.Second)
+			if err != nil {
+				t.Fatalf("new listener: %v", err)
+			}
+			defer l.Close()
+
+			client, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer client.Close()
+			if _, err := client.Write([]byte(tt.send)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			client.(*net.TCPConn).CloseWrite()
+
+			conn, err := l.Accept()
+			if err != nil {
+				t.Fatalf("accept: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			data, err := io.ReadAll(conn)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("read error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if string(data) != tt.wantData {
+				t.Errorf("data = %q, want %q", data, tt.wantData)
+			}
+
+			wantRemote := tt.wantRemote
+			if wantRemote == "" {
+				wantRemote = client.LocalAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != wantRemote {
+				t.Errorf("RemoteAddr() = %s, want %s", got, wantRemote)
+			}
+			if header := conn.(*Conn).Header(); (header == nil) != tt.wantNoHeader {
+				t.Errorf("Header() = %+v, want a header: %v", header, !tt.wantNoHeader)
+			}
+		})
+	}
+}
+
+func TestListenerHeaderTimeout(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	l, err := NewListener(inner, []string{"127.0.0.1"}, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("new listener: %v", err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+	client.Write([]byte("PROXY TCP4 "))
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+
+	var netErr net.Error
+	if _, err := conn.Read(make([]byte, 1)); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("read error = %v, want a timeout", err)
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8", " 192.0.2.1 ", "", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("ParseCIDRs() failed: %v", err)
+	}
+	var got []string
+	for _, network := range networks {
+		got = append(got, network.String())
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.1/32", "2001:db8::1/128"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ParseCIDRs() = %v, want %v", got, want)
+	}
+
+	for _, invalid := range []string{"10.0.0.0/33", "not-an-ip"} {
+		if _, err := ParseCIDRs([]string{invalid}); err == nil {
+			t.Errorf("ParseCIDRs(%q) succeeded, want error", invalid)
+		}
+	}
+}
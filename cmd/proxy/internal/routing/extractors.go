@@ -0,0 +1,290 @@
+package routing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+)
+
+// Extractor names accepted by NewExtractors (ROUTING_EXTRACTORS).
+const (
+	ExtractorSNI             = "sni"
+	ExtractorUsernameSuffix  = "username_suffix"
+	ExtractorUsernamePrefix  = "username_prefix"
+	ExtractorDatabase        = "database"
+	ExtractorOptions         = "options"
+	ExtractorApplicationName = "application_name"
+)
+
+// NewExtractors builds the extractor chain in the given order.
+// sniTemplate is required only when the "sni" extractor is listed.
+func NewExtractors(names []string, sniTemplate *core.HostnameTemplate) ([]core.RoutingExtractor, error) {
+	extractors := make([]core.RoutingExtractor, 0, len(names))
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case ExtractorSNI:
+			if sniTemplate == nil {
+				return nil, fmt.Errorf("routing extractor %q requires SNI_HOSTNAME_TEMPLATE", ExtractorSNI)
+			}
+			extractors = append(extractors, &SNI{Template: sniTemplate})
+		case ExtractorUsernameSuffix:
+			extractors = append(extractors, UsernameSuffix{})
+		case ExtractorUsernamePrefix:
+			extractors = append(extractors, UsernamePrefix{})
+		case ExtractorDatabase:
+			extractors = append(extractors, Database{})
+		case ExtractorOptions:
+			extractors = append(extractors, Options{})
+		case ExtractorApplicationName:
+			extractors = append(extractors, ApplicationName{})
+		default:
+			return nil, fmt.Errorf("unknown routing extractor: %s", name)
+		}
+	}
+	return extractors, nil
+}
+
+// SNI routes by TLS server name, e.g. "db-prod.pool.db.example.com" with the
+// template "{deployment}.{pool?}.db.example.com". Parameters are left untouched.
+type SNI struct {
+	Template *core.HostnameTemplate
+}
+
+func (e *SNI) Name() string { return ExtractorSNI }
+
+func (e *SNI) Extract(req *core.RoutingRequest) (core.RoutingTarget, bool) {
+	if req.ServerName == "" {
+		return core.RoutingTarget{}, false
+	}
+	deploymentID, pooled, ok := e.Template.Match(req.ServerName)
+	if !ok {
+		return core.RoutingTarget{}, false
+	}
+	return core.RoutingTarget{DeploymentID: deploymentID, Pooled: pooled}, true
+}
+
+// UsernameSuffix routes by "username.deployment_id[.pool]" and forwards "username".
+// Examples:
+//
+//	alice.db-prod.pool     → username=alice, deployment_id=db-prod, pooled=true
+//	bob.team-1992252154561 → username=bob, deployment_id=team-1992252154561, pooled=false
+type UsernameSuffix struct{}
+
+func (UsernameSuffix) Name() string { return ExtractorUsernameSuffix }
+
+func (UsernameSuffix) Extract(req *core.RoutingRequest) (core.RoutingTarget, bool) {
+	user := req.Params["user"]
+	username, target, ok := splitSuffix(user)
+	if !ok {
+		return core.RoutingTarget{}, false
+	}
+	req.Params["user"] = username
+	defaultDatabase(req.Params, user)
+	return target, true
+}
+
+// UsernamePrefix routes by "deployment_id[.pool].username" and forwards "username".
+type UsernamePrefix struct{}
+
+func (UsernamePrefix) Name() string { return ExtractorUsernamePrefix }
+
+func (UsernamePrefix) Extract(req *core.RoutingRequest) (core.RoutingTarget, bool) {
+	user := req.Params["user"]
+	username, target, ok := splitPrefix(user)
+	if !ok {
+		return core.RoutingTarget{}, false
+	}
+	req.Params["user"] = username
+	defaultDatabase(req.Params, user)
+	return target, true
+}
+
+// Database routes by "dbname.deployment_id[.pool]" and forwards "dbname".
+type Database struct{}
+
+func (Database) Name() string { return ExtractorDatabase }
+
+func (Database) Extract(req *core.RoutingRequest) (core.RoutingTarget, bool) {
+	dbName, target, ok := splitSuffix(req.Params["database"])
+	if !ok {
+		return core.RoutingTarget{}, false
+	}
+	req.Params["database"] = dbName
+	return target, true
+}
+
+// Options routes by run-time parameters in the "options" startup parameter,
+// e.g. PGOPTIONS="-c xdb.deployment=db-prod -c xdb.pooled=true".
+// The xdb.* settings are removed before the options are forwarded.
+type Options struct{}
+
+const (
+	optionDeployment = "xdb.deployment"
+	optionPooled     = "xdb.pooled"
+)
+
+func (Options) Name() string { return ExtractorOptions }
+
+func (Options) Extract(req *core.RoutingRequest) (core.RoutingTarget, bool) {
+	options, ok := req.Params["options"]
+	if !ok {
+		return core.RoutingTarget{}, false
+	}
+
+	var target core.RoutingTarget
+	var kept []string
+	tokens := splitOptions(options)
+	for i := 0; i < len(tokens); i++ {
+		// Accepted forms: "-c name=value", "-cname=value", "--name=value"
+		setting := ""
+		switch {
+		case tokens[i] == "-c" && i+1 < len(tokens):
+			setting = tokens[i+1]
+		case strings.HasPrefix(tokens[i], "-c") && len(tokens[i]) > 2:
+			setting = tokens[i][2:]
+		case strings.HasPrefix(tokens[i], "--"):
+			setting = tokens[i][2:]
+		}
+
+		name, value, _ := strings.Cut(setting, "=")
+		switch name {
+		case optionDeployment:
+			target.DeploymentID = value
+		case optionPooled:
+			pooled, err := strconv.ParseBool(value)
+			if err != nil {
+				return core.RoutingTarget{}, false
+			}
+			target.Pooled = pooled
+		default:
+			kept = append(kept, tokens[i])
+			continue
+		}
+		if tokens[i] == "-c" {
+			i++ // skip the consumed "name=value" token
+		}
+	}
+
+	if target.DeploymentID == "" {
+		return core.RoutingTarget{}, false
+	}
+
+	if len(kept) == 0 {
+		delete(req.Params, "options")
+	} else {
+		req.Params["options"] = joinOptions(kept)
+	}
+	return target, true
+}
+
+// ApplicationName routes by "name@deployment_id[.pool]" in application_name and forwards "name".
+type ApplicationName struct{}
+
+func (ApplicationName) Name() string { return ExtractorApplicationName }
+
+func (ApplicationName) Extract(req *core.RoutingRequest) (core.RoutingTarget, bool) {
+	appName := req.Params["application_name"]
+	at := strings.LastIndex(appName, "@")
+	if at < 0 {
+		return core.RoutingTarget{}, false
+	}
+
+	target := core.RoutingTarget{DeploymentID: appName[at+1:]}
+	if deploymentID, ok := strings.CutSuffix(target.DeploymentID, ".pool"); ok {
+		target.DeploymentID = deploymentID
+		target.Pooled = true
+	}
+	if target.DeploymentID == "" {
+		return core.RoutingTarget{}, false
+	}
+
+	if name := appName[:at]; name != "" {
+		req.Params["application_name"] = name
+	} else {
+		delete(req.Params, "application_name")
+	}
+	return target, true
+}
+
+// splitSuffix parses "name.deployment_id[.pool]".
+func splitSuffix(value string) (string, core.RoutingTarget, bool) {
+	parts := strings.Split(value, ".")
+	if parts[len(parts)-1] == "pool" {
+		if len(parts) < 3 {
+			return "", core.RoutingTarget{}, false
+		}
+		name := strings.Join(parts[:len(parts)-2], ".")
+		return name, core.RoutingTarget{DeploymentID: parts[len(parts)-2], Pooled: true}, name != "" && parts[len(parts)-2] != ""
+	}
+	if len(parts) < 2 {
+		return "", core.RoutingTarget{}, false
+	}
+	name := strings.Join(parts[:len(parts)-1], ".")
+	return name, core.RoutingTarget{DeploymentID: parts[len(parts)-1]}, name != "" && parts[len(parts)-1] != ""
+}
+
+// splitPrefix parses "deployment_id[.pool].name".
+func splitPrefix(value string) (string, core.RoutingTarget, bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) < 2 || parts[0] == "" {
+		return "", core.RoutingTarget{}, false
+	}
+	target := core.RoutingTarget{DeploymentID: parts[0]}
+	rest := parts[1:]
+	if len(rest) >= 2 && rest[0] == "pool" {
+		target.Pooled = true
+		rest = rest[1:]
+	}
+	name := strings.Join(rest, ".")
+	return name, target, name != ""
+}
+
+// defaultDatabase defaults the database to postgres if not provided OR if it equals the original user.
+// Some PostgreSQL clients (like psql) automatically use username as database when not specified.
+// This causes issues when username is "postgres.team-1992252154561" and gets used as database name.
+func defaultDatabase(params map[string]string, originalUser string) {
+	if dbName, ok := params["database"]; !ok || dbName == "" || dbName == originalUser {
+		params["database"] = "postgres"
+	}
+}
+
+// splitOptions splits the "options" startup parameter like PostgreSQL does:
+// on whitespace, with backslash escaping the next character.
+func splitOptions(options string) []string {
+	var tokens []string
+	var current strings.Builder
+	inToken, escaped := false, false
+	for _, r := range options {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped, inToken = true, true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inToken {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				inToken = false
+			}
+		default:
+			current.WriteRune(r)
+			inToken = true
+		}
+	}
+	if inToken {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+func joinOptions(tokens []string) string {
+	escaped := make([]string, len(tokens))
+	for i, token := range tokens {
+		token = strings.ReplaceAll(token, `\`, `\\`)
+		escaped[i] = strings.ReplaceAll(token, " ", `\ `)
+	}
+	return strings.Join(escaped, " ")
+}
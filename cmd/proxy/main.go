@@ -2,15 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/api"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/balancer"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/config"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/core"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/discovery/cache"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/factory"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/healthcheck"
 	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/logger"
+	"github.com/hasirciogluhq/xdatabase-proxy/cmd/proxy/internal/proxyproto"
 )
 
 func main() {
@@ -36,13 +43,60 @@ func main() {
 	healthServer.Start()
 	logger.Info("Health server started", "port", cfg.HealthServerPort)
 
+	// Active sessions per backend address, shared by the resolver and the proxy
+	connections := balancer.NewConnectionTracker()
+
 	// Create backend resolver
-	resolverFactory := factory.NewResolverFactory(cfg)
+	resolverFactory := factory.NewResolverFactory(cfg, connections)
 	resolver, clientset, err := resolverFactory.Create(ctx)
 	if err != nil {
 		logger.Fatal("Failed to create backend resolver", "error", err)
 	}
 
+	// Manage static routes at runtime (optional)
+	if cfg.RoutesAPIEnabled {
+		routes := resolverFactory.StaticResolver()
+		if routes == nil {
+			logger.Fatal("Routes API requires static discovery")
+		}
+		healthServer.HandleRoutes(routes, cfg.APIToken)
+		logger.Info("Routes API enabled", "persist", cfg.RoutesAPIPersist)
+	}
+
+	// Reuse resolutions and ride out discovery outages (optional)
+	if cfg.ResolverCacheEnabled {
+		resolver = cache.NewResolver(resolver, cache.Options{
+			TTL:         cfg.ResolverCacheTTL,
+			NegativeTTL: cfg.ResolverCacheNegativeTTL,
+			StaleTTL:    cfg.ResolverCacheStaleTTL,
+			MaxEntries:  cfg.ResolverCacheMaxEntries,
+		})
+		logger.Info("Resolver cache enabled",
+			"ttl", cfg.ResolverCacheTTL,
+			"negative_ttl", cfg.ResolverCacheNegativeTTL,
+			"stale_ttl", cfg.ResolverCacheStaleTTL)
+	}
+
+	// Probe backends in the background and eject unhealthy ones (optional)
+	if cfg.HealthCheckEnabled {
+		probe, err := healthcheck.NewProbe(cfg.HealthCheckType, cfg.BackendProxyProtocol)
+		if err != nil {
+			logger.Fatal("Invalid health check configuration", "error", err)
+		}
+		checker := healthcheck.NewChecker(resolver, healthcheck.Options{
+			Probe:            probe,
+			Interval:         cfg.HealthCheckInterval,
+			Timeout:          cfg.HealthCheckTimeout,
+			FailureThreshold: cfg.HealthCheckFailureThreshold,
+			SuccessThreshold: cfg.HealthCheckSuccessThreshold,
+		})
+		checker.Start()
+		defer checker.Stop()
+		resolver = checker
+		healthServer.Handle("/backends", checker.Handler())
+		logger.Info("Backend health checking enabled", "type", cfg.HealthCheckType, "interval", cfg.HealthCheckInterval)
+	}
+
 	// Create TLS provider (optional)
 	var tlsProvider core.TLSProvider
 	if cfg.TLSEnabled {
@@ -63,12 +117,17 @@ func main() {
 	}
 
 	// Create protocol-specific proxy handler
-	proxyFactory := factory.NewProxyFactory(cfg)
+	proxyFactory := factory.NewProxyFactory(cfg, connections)
 	connectionHandler, err := proxyFactory.Create(ctx, tlsProvider, resolver)
 	if err != nil {
 		logger.Fatal("Failed to create proxy handler", "error", err)
 	}
 
+	// /ready aggregates the state of discovery and TLS with the listener state
+	for _, check := range append(resolverFactory.ReadinessChecks(), proxyFactory.ReadinessChecks()...) {
+		healthServer.AddReadinessCheck(check)
+	}
+
 	// Start TCP listener
 	listener, err := net.Listen("tcp", ":"+cfg.ProxyStartPort)
 	if err != nil {
@@ -76,18 +135,66 @@ func main() {
 	}
 	logger.Info("Proxy listening", "port", cfg.ProxyStartPort, "database", cfg.DatabaseType)
 
+	// Decode PROXY protocol headers from load balancers (optional)
+	if cfg.ProxyProtocolEnabled {
+		listener, err = proxyproto.NewListener(listener, cfg.ProxyProtocolTrustedCIDRs, cfg.ProxyProtocolHeaderTimeout)
+		if err != nil {
+			logger.Fatal("Invalid PROXY protocol configuration", "error", err)
+		}
+		logger.Info("PROXY protocol enabled", "trusted_cidrs", cfg.ProxyProtocolTrustedCIDRs)
+	}
+
 	// Create and start server
 	server := &core.Server{
 		Listener:          listener,
 		ConnectionHandler: connectionHandler,
+		Readiness:         healthServer,
+		DrainTimeout:      cfg.ShutdownDrainTimeout,
 	}
 
-	// Mark as ready
+	// The listener is bound; /ready still requires the other checks to pass
 	healthServer.SetReady(true)
 	logger.Info("Proxy is ready to accept connections")
 
-	// Start serving (blocking)
-	if err := server.Serve(); err != nil {
-		logger.Fatal("Server error", "error", err)
+	// Start serving in the background so we can react to termination signals
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve()
+	}()
+
+	sigCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-serveErr:
+		if err != nil && !errors.Is(err, core.ErrServerClosed) {
+			logger.Fatal("Server error", "error", err)
+		}
+	case <-sigCtx.Done():
+		// Stop the first notification; the drain context below listens for the next signal
+		stop()
+		logger.Info("Shutdown signal received, draining connections",
+			"active_connections", server.ActiveConnections(),
+			"drain_timeout", cfg.ShutdownDrainTimeout)
+
+		// A second signal cuts the drain short instead of waiting on long-lived sessions
+		drainCtx, cancelDrain := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+		defer cancelDrain()
+
+		switch err := server.Shutdown(drainCtx); {
+		case errors.Is(err, context.DeadlineExceeded):
+			logger.Warn("Drain deadline exceeded, remaining connections were closed")
+		case errors.Is(err, context.Canceled):
+			logger.Warn("Second shutdown signal received, remaining connections were closed")
+		case err != nil:
+			logger.Warn("Failed to close listener", "error", err)
+		default:
+			logger.Info("All connections drained")
+		}
+	}
+
+	if err := healthServer.Stop(ctx); err != nil {
+		logger.Warn("Failed to stop health server", "error", err)
 	}
+	logger.Info("xdatabase-proxy stopped")
 }